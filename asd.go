@@ -4,36 +4,67 @@ package main
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
-	"gopkg.in/yaml.v2"
 )
 
 type Command struct {
-	Name      string `yaml:"name"`
-	Extension string `yaml:"extension"`
+	Name        string   `yaml:"name" json:"name" toml:"name"`
+	Runner      string   `yaml:"runner" json:"runner" toml:"runner"`
+	File        string   `yaml:"file,omitempty" json:"file,omitempty" toml:"file,omitempty"`
+	Interpreter string   `yaml:"interpreter,omitempty" json:"interpreter,omitempty" toml:"interpreter,omitempty"`
+	Argv        []string `yaml:"argv,omitempty" json:"argv,omitempty" toml:"argv,omitempty"`
+	Flags       string   `yaml:"flags,omitempty" json:"flags,omitempty" toml:"flags,omitempty"`
+	PassHelp    bool     `yaml:"pass_help,omitempty" json:"pass_help,omitempty" toml:"pass_help,omitempty"`
+	Steps       []Step   `yaml:"steps,omitempty" json:"steps,omitempty" toml:"steps,omitempty"`
+	Needs       []string `yaml:"needs,omitempty" json:"needs,omitempty" toml:"needs,omitempty"`
+	// Options and Arguments declare the command's interface for asd's help
+	// and completion, see new-go-command --flag and --arg
+	Options     []FlagSpec `yaml:"options,omitempty" json:"options,omitempty" toml:"options,omitempty"`
+	Arguments   []ArgSpec  `yaml:"arguments,omitempty" json:"arguments,omitempty" toml:"arguments,omitempty"`
+	Metadata    `yaml:",inline"`
+	RunSettings `yaml:",inline"`
+
+	// Filled in by loadLayeredConfig, never written to commands.yaml
+	Layer         string      `yaml:"-" json:"-" toml:"-"`
+	Dir           string      `yaml:"-" json:"-" toml:"-"`
+	BaseDir       string      `yaml:"-" json:"-" toml:"-"`
+	Resolved      RunSettings `yaml:"-" json:"-" toml:"-"`
+	ResolvedHooks hookChain   `yaml:"-" json:"-" toml:"-"`
 }
 
 type Category struct {
-	Name          string     `yaml:"name"`
-	Path          string     `yaml:"path"`
-	Commands      []Command  `yaml:"commands"`
-	Subcategories []Category `yaml:"subcategories"`
+	Name          string     `yaml:"name" json:"name" toml:"name"`
+	Path          string     `yaml:"path" json:"path" toml:"path"`
+	Commands      []Command  `yaml:"commands" json:"commands" toml:"commands"`
+	Subcategories []Category `yaml:"subcategories" json:"subcategories" toml:"subcategories"`
+	Metadata      `yaml:",inline"`
+	RunSettings   `yaml:",inline"`
+	Hooks         `yaml:",inline"`
+
+	// Filled in by loadLayeredConfig, never written to commands.yaml.
+	// OwnSettings and OwnHooks are the category's own settings and hooks,
+	// combined across the layers defining it, with paths resolved.
+	Layer       string      `yaml:"-" json:"-" toml:"-"`
+	Dir         string      `yaml:"-" json:"-" toml:"-"`
+	OwnSettings RunSettings `yaml:"-" json:"-" toml:"-"`
+	OwnHooks    hookChain   `yaml:"-" json:"-" toml:"-"`
 }
 
 type Config struct {
-	Categories []Category `yaml:"categories"`
+	Version    int `yaml:"version" json:"version" toml:"version"`
+	Hooks      `yaml:",inline"`
+	Categories []Category `yaml:"categories" json:"categories" toml:"categories"`
 }
 
 type GPT4Request struct {
@@ -48,7 +79,7 @@ type GPT4Response struct {
 }
 
 var newCategoryCmd = &cobra.Command{
-	Use:   "new-category [name] [parentCategoryName]",
+	Use:   "new-category [name] [parentCategoryPath]",
 	Short: "Creates a new category",
 	Args:  cobra.RangeArgs(1, 2),
 	Run: func(cmd *cobra.Command, args []string) {
@@ -57,37 +88,28 @@ var newCategoryCmd = &cobra.Command{
 		parentCategoryPath := "."
 		if len(args) > 1 {
 			parentCategoryName = args[1]
-			// Find the parent category path (replace with your actual function)
-			var err error
-			// Read existing YAML
-			data, err := ioutil.ReadFile("commands.yaml")
+			// Find the parent category path
+			config, err := registry().Load()
 			if err != nil {
-				fmt.Printf("Could not read commands.yaml: %s\n", err)
-				return
-			}
-
-			var config Config
-			err = yaml.Unmarshal(data, &config)
-			if err != nil {
-				fmt.Printf("Could not unmarshal commands.yaml: %s\n", err)
+				fail(exitConfig, "Could not load %s: %s", configPath, err)
 				return
 			}
 			parentCategoryPath, err = findCategoryPath(parentCategoryName, config.Categories)
 			if err != nil {
-				fmt.Printf("Parent category not found: %s\n", err)
+				fail(codeFor(err), "Parent category not found: %s", err)
 				return
 			}
 		}
 
 		err := updateYAMLWithNewCategory(categoryName, parentCategoryName)
 		if err != nil {
-			fmt.Printf("Failed to update YAML: %s\n", err)
+			fail(codeFor(err), "Failed to update YAML: %s", err)
 			return
 		}
 
-		err = createCategoryFolder(categoryName, parentCategoryPath)
+		err = createCategoryFolder(categoryName, resolveCategoryPath(parentCategoryPath))
 		if err != nil {
-			fmt.Printf("Failed to create folder: %s\n", err)
+			fail(exitFileSystem, "Failed to create folder: %s", err)
 			return
 		}
 
@@ -96,36 +118,35 @@ var newCategoryCmd = &cobra.Command{
 }
 
 var newGoCommandCmd = &cobra.Command{
-	Use:   "new-go-command [commandName] [parentCategoryName]",
+	Use:   "new-go-command [commandName] [categoryPath]",
 	Short: "Creates a new Go command under a category or subcategory",
 	Args:  cobra.ExactArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
 		commandName := args[0]
 		parentCategoryName := args[1]
 
-		// Read existing YAML
-		data, err := ioutil.ReadFile("commands.yaml")
-		if err != nil {
-			panic(err)
-		}
-
-		var config Config
-		err = yaml.Unmarshal(data, &config)
-		if err != nil {
-			panic(err)
-		}
-
-		parentCategory, err := findParentCategory(parentCategoryName, config.Categories)
+		options, arguments, err := parseSpecs(flagSpecs, argSpecs)
 		if err != nil {
-			fmt.Printf("Error: %s\n", err)
+			fail(exitUsage, "Failed to add new Go command: %s", err)
 			return
 		}
 
-		err = addNewGoCommandToCategory(commandName, parentCategory, &config)
+		var sourceDir string
+		err = registry().Update(func(config *Config) error {
+			parentCategory, err := findParentCategory(parentCategoryName, config.Categories)
+			if err != nil {
+				return err
+			}
+			sourceDir = resolveCategoryPath(parentCategory.Path)
+			return addNewGoCommandToCategory(commandName, parentCategory, config, options, arguments)
+		})
 		if err != nil {
-			fmt.Printf("Failed to add new Go command: %s\n", err)
+			fail(codeFor(err), "Failed to add new Go command: %s", err)
 			return
 		}
+		if len(options) > 0 || len(arguments) > 0 {
+			tidyGoModule(sourceDir)
+		}
 
 		fmt.Printf("Added new Go command: %s under category: %s\n", commandName, parentCategoryName)
 	},
@@ -134,51 +155,26 @@ var newGoCommandCmd = &cobra.Command{
 var scriptContent string
 
 var newLinuxCommandCmd = &cobra.Command{
-	Use:   "new-linux-command [name] [category]",
+	Use:   "new-linux-command [name] [categoryPath]",
 	Short: "Creates a new Linux command under a category or sub-category",
 	Args:  cobra.ExactArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
 		commandName := args[0]
 		categoryName := args[1]
 
-		// Read existing YAML
-		data, err := ioutil.ReadFile("commands.yaml")
-		if err != nil {
-			panic(err)
-		}
-
-		var config Config
-		err = yaml.Unmarshal(data, &config)
-		if err != nil {
-			panic(err)
-		}
-
-		// Find the category path
-		categoryPath, err := findCategoryPath(categoryName, config.Categories)
-		if err != nil {
-			panic(err)
-		}
-
-		// Add new command and update YAML
-		_, err = addNewCommandToYAML(commandName, categoryName, "linux", &config.Categories)
-		if err != nil {
-			panic(err)
-		}
-
-		// Create the shell script
-		err = createShellScript(categoryPath, commandName, "")
-		if err != nil {
-			panic(err)
-		}
+		err := registry().Update(func(config *Config) error {
+			// Find the category
+			category, err := findParentCategory(categoryName, config.Categories)
+			if err != nil {
+				return err
+			}
 
-		// Update YAML file
-		data, err = yaml.Marshal(&config)
+			// Add new command to the config and create the shell script
+			return addNewCommandToYAML(commandName, category, categoryPathOf(category, config.Categories), "linux")
+		})
 		if err != nil {
-			panic(err)
-		}
-		err = ioutil.WriteFile("commands.yaml", data, 0644)
-		if err != nil {
-			panic(err)
+			fail(codeFor(err), "Failed to add new Linux command: %s", err)
+			return
 		}
 
 		fmt.Printf("Created new Linux command: %s in category: %s\n", commandName, categoryName)
@@ -189,80 +185,64 @@ var compileCmd = &cobra.Command{
 	Use:   "compile",
 	Short: "Compiles all .go files",
 	Run: func(cmd *cobra.Command, args []string) {
-		// Read existing YAML
-		data, err := ioutil.ReadFile("commands.yaml")
-		if err != nil {
-			panic(err)
-		}
-
-		var config Config
-		err = yaml.Unmarshal(data, &config)
+		config, err := registry().Load()
 		if err != nil {
-			panic(err)
+			fail(exitConfig, "Could not load %s: %s", configPath, err)
+			return
 		}
 
+		skip := goRunSources()
 		for _, category := range config.Categories {
-			compileAllGoFiles(category.Path)
+			compileAllGoFiles(resolveCategoryPath(category.Path), skip)
 		}
 	},
 }
 
 var removeCommandCmd = &cobra.Command{
-	Use:   "remove [name] [category]",
+	Use:   "remove [name] [categoryPath]",
 	Short: "Removes a command from a category or sub-category",
 	Args:  cobra.ExactArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
 		commandName := args[0]
 		categoryName := args[1]
 
-		// Read existing YAML
-		data, err := ioutil.ReadFile("commands.yaml")
-		if err != nil {
-			panic(err)
-		}
-
-		var config Config
-		err = yaml.Unmarshal(data, &config)
-		if err != nil {
-			panic(err)
-		}
+		var categoryPath string
+		var removed Command
+		err := registry().Update(func(config *Config) error {
+			// Find the parent category
+			parentCategory, err := findParentCategory(categoryName, config.Categories)
+			if err != nil {
+				return err
+			}
+			categoryPath = parentCategory.Path
+			for _, command := range parentCategory.Commands {
+				if command.Name == commandName {
+					removed = command
+				}
+			}
 
-		// Find the parent category
-		parentCategory, err := findParentCategory(categoryName, config.Categories)
+			// Remove command from the config
+			return removeCommandFromYAML(commandName, parentCategory)
+		})
 		if err != nil {
-			fmt.Printf("Error: %s\n", err)
+			fail(codeFor(err), "Error: %s", err)
 			return
 		}
 
-		// Remove command and update YAML
-		err = removeCommandFromYAML(commandName, parentCategory.Name, &config.Categories)
+		// Remove the source and any built artifact
+		runner, err := runnerFor(removed)
 		if err != nil {
-			panic(err)
-		}
-
-		// Update YAML file
-		data, err = yaml.Marshal(&config)
-		if err != nil {
-			panic(err)
-		}
-		err = ioutil.WriteFile("commands.yaml", data, 0644)
-		if err != nil {
-			panic(err)
+			fail(exitConfig, "Error: %s", err)
+			return
 		}
-
-		// Remove the corresponding .go or .sh file
-		filePath := filepath.Join(parentCategory.Path, commandName+".go")
-		if _, err := os.Stat(filePath); !os.IsNotExist(err) {
-			err = os.Remove(filePath)
-			if err != nil {
-				fmt.Printf("Error removing file %s: %s\n", filePath, err.Error())
-				return
+		dir := resolveCategoryPath(categoryPath)
+		for _, filePath := range []string{runner.Source(dir, removed), runner.Artifact(dir, removed)} {
+			if _, err := os.Stat(filePath); os.IsNotExist(err) {
+				continue
 			}
-		} else {
-			filePath = filepath.Join(parentCategory.Path, commandName+".sh")
 			err = os.Remove(filePath)
 			if err != nil {
-				fmt.Printf("Error removing file %s: %s\n", filePath, err.Error())
+				fail(exitFileSystem, "Error removing file %s: %s", filePath, err.Error())
 				return
 			}
 		}
@@ -282,14 +262,16 @@ var generateGoCommandCmd = &cobra.Command{
 		// Send the prompt to GPT-4 and get the generated code
 		generatedCode, err := sendPromptToGPT4(fmt.Sprintf("make command %s %s, please provide the answer as pure code which can be put in a .go file for compilation.", commandName, prompt))
 		if err != nil {
-			panic(err)
+			fail(exitFailure, "Failed to generate code: %s", err)
+			return
 		}
 
 		// Create .go file with the generated code
 		filePath := filepath.Join("./generated-commands", commandName+".go")
 		err = ioutil.WriteFile(filePath, []byte(generatedCode), 0644)
 		if err != nil {
-			panic(err)
+			fail(exitFileSystem, "Failed to write %s: %s", filePath, err)
+			return
 		}
 
 		fmt.Printf("Generated Go command: %s based on prompt: %s\n", commandName, prompt)
@@ -307,14 +289,16 @@ var generateLinuxCommandCmd = &cobra.Command{
 		// Send the prompt to GPT-4 and get the generated code
 		generatedCode, err := sendPromptToGPT4(fmt.Sprintf("make Linux command %s %s, please provide the answer as pure code which can be put in a .sh file for execution.", commandName, prompt))
 		if err != nil {
-			panic(err)
+			fail(exitFailure, "Failed to generate code: %s", err)
+			return
 		}
 
 		// Create .sh file with the generated code
 		filePath := filepath.Join("./generated-commands", commandName+".sh")
 		err = ioutil.WriteFile(filePath, []byte(generatedCode), 0755) // 0755 to make it executable
 		if err != nil {
-			panic(err)
+			fail(exitFileSystem, "Failed to write %s: %s", filePath, err)
+			return
 		}
 
 		fmt.Printf("Generated Linux command: %s based on prompt: %s\n", commandName, prompt)
@@ -333,19 +317,19 @@ var completionCmd = &cobra.Command{
 		case "bash":
 			err := cmd.Root().GenBashCompletion(os.Stdout)
 			if err != nil {
-				fmt.Println("Error creating auto-complete files:", err)
+				fail(exitFailure, "Error creating auto-complete files: %s", err)
 				return
 			}
 		case "zsh":
 			err := cmd.Root().GenZshCompletion(os.Stdout)
 			if err != nil {
-				fmt.Println("Error creating auto-complete files:", err)
+				fail(exitFailure, "Error creating auto-complete files: %s", err)
 				return
 			}
 		case "fish":
 			err := cmd.Root().GenFishCompletion(os.Stdout, true)
 			if err != nil {
-				fmt.Println("Error creating auto-complete files:", err)
+				fail(exitFailure, "Error creating auto-complete files: %s", err)
 				return
 			}
 		case "powershell":
@@ -353,7 +337,7 @@ var completionCmd = &cobra.Command{
 			err := cmd.Root().GenPowerShellCompletionWithDesc(os.Stdout)
 			fmt.Println("Error creating auto-complete files:", err)
 			if err != nil {
-				fmt.Println("Error creating auto-complete files:", err)
+				fail(exitFailure, "Error creating auto-complete files: %s", err)
 				return
 			}
 		}
@@ -361,71 +345,36 @@ var completionCmd = &cobra.Command{
 }
 
 var listCmd = &cobra.Command{
-	Use:   "list [category] [subCategory1] [subCategory2] ...",
+	Use:   "list [categoryPath | category subCategory1 subCategory2 ...]",
 	Short: "Lists built-in commands, categories, and commands for a given category and subcategories",
 	Run: func(cmd *cobra.Command, args []string) {
-		// Read existing YAML
-		data, err := ioutil.ReadFile("commands.yaml")
+		config, err := loadLayeredConfig()
 		if err != nil {
-			panic(err)
-		}
-
-		var config Config
-		err = yaml.Unmarshal(data, &config)
-		if err != nil {
-			panic(err)
+			fail(exitConfig, "Could not load commands: %s", err)
+			return
 		}
 
 		listCommandsAndCategories(args, config.Categories)
 	},
 }
 
-// addNewGoCommandToCategory adds a new Go command to a given category,
-// updates the commands.yaml file, and creates a new .go file for the command.
-func addNewGoCommandToCategory(commandName string, parentCategory *Category, config *Config) error {
+// addNewGoCommandToCategory adds a new Go command to a given category
+// and creates a new .go file for the command. The caller is expected to
+// save config, normally through RegistryStore.Update.
+func addNewGoCommandToCategory(commandName string, parentCategory *Category, config *Config, options []FlagSpec, arguments []ArgSpec) error {
 	// Add the new command to the parent category
 	newCommand := Command{
 		Name:      commandName,
-		Extension: ".exe",
+		Runner:    runnerGo,
+		Options:   options,
+		Arguments: arguments,
+		Metadata:  commandMetadata,
 	}
 	parentCategory.Commands = append(parentCategory.Commands, newCommand)
 
-	// Update the commands.yaml file
-	updatedData, err := yaml.Marshal(&config) // Assume 'config' contains the updated categories
-	if err != nil {
-		return err
-	}
-	err = ioutil.WriteFile("commands.yaml", updatedData, 0644)
-	if err != nil {
-		return err
-	}
-
-	// Create a new .go file for the command
-	goFilePath := filepath.Join(parentCategory.Path, fmt.Sprintf("%s.go", commandName))
-
-	// Create parent directory if it doesn't exist
-	parentDir := filepath.Dir(goFilePath)
-	if _, err := os.Stat(parentDir); os.IsNotExist(err) {
-		err = os.MkdirAll(parentDir, 0755)
-		if err != nil {
-			return err
-		}
-	}
-
-	goFileContent := `package main
-
-import "fmt"
-
-func main() {
-    fmt.Println("Hello, this is ` + commandName + `!")
-}
-`
-	err = ioutil.WriteFile(goFilePath, []byte(goFileContent), 0644)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	// Create the .go file, and anything else the template adds
+	categoryPath := categoryPathOf(parentCategory, config.Categories)
+	return scaffoldCommand(resolveCategoryPath(parentCategory.Path), newCommand, categoryPath)
 }
 
 func addToPath() error {
@@ -463,30 +412,82 @@ func addToPath() error {
 	return nil
 }
 
-// findParentCategory searches for a category or subcategory by name
-// using a breadth-first search approach.
+// findParentCategory looks up a category by its slash-separated path, such
+// as "ops/db". A bare name is searched for in the whole tree using a
+// breadth-first search and must match exactly one category.
 func findParentCategory(name string, categories []Category) (*Category, error) {
+	name = strings.Trim(name, "/")
+	if strings.Contains(name, "/") {
+		return findCategoryByPath(strings.Split(name, "/"), categories)
+	}
+
+	matches := findCategoriesByName(name, categories)
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("category %s %w", name, errNotFound)
+	}
+	if len(matches) > 1 {
+		var paths []string
+		for _, match := range matches {
+			paths = append(paths, match.path)
+		}
+		return nil, fmt.Errorf("category name %s %w, use one of: %s", name, errAmbiguous, strings.Join(paths, ", "))
+	}
+	return matches[0].category, nil
+}
+
+// findCategoryByPath follows the path segments from the root categories down.
+func findCategoryByPath(segments []string, categories []Category) (*Category, error) {
+	var current *Category
+	for i, segment := range segments {
+		current = nil
+		for j := range categories {
+			if categories[j].Name == segment {
+				current = &categories[j]
+				break
+			}
+		}
+		if current == nil {
+			return nil, fmt.Errorf("category %s %w", strings.Join(segments[:i+1], "/"), errNotFound)
+		}
+		categories = current.Subcategories
+	}
+	if current == nil {
+		return nil, fmt.Errorf("category %w", errNotFound)
+	}
+	return current, nil
+}
+
+type categoryMatch struct {
+	category *Category
+	path     string
+}
+
+// findCategoriesByName returns every category with the given name together
+// with its full path, in breadth-first order.
+func findCategoriesByName(name string, categories []Category) []categoryMatch {
 	// Initialize a queue with the root categories
-	queue := make([]*Category, len(categories))
+	queue := make([]categoryMatch, len(categories))
 	for i := range categories {
-		queue[i] = &categories[i]
+		queue[i] = categoryMatch{category: &categories[i], path: categories[i].Name}
 	}
 
 	// Perform BFS
+	var matches []categoryMatch
 	for len(queue) > 0 {
-		var nextQueue []*Category
-		for _, category := range queue {
-			if category.Name == name {
-				return category, nil
+		var nextQueue []categoryMatch
+		for _, match := range queue {
+			if match.category.Name == name {
+				matches = append(matches, match)
 			}
-			for i := range category.Subcategories {
-				nextQueue = append(nextQueue, &category.Subcategories[i])
+			for i := range match.category.Subcategories {
+				subcategory := &match.category.Subcategories[i]
+				nextQueue = append(nextQueue, categoryMatch{category: subcategory, path: match.path + "/" + subcategory.Name})
 			}
 		}
 		queue = nextQueue
 	}
 
-	return nil, errors.New("category not found")
+	return matches
 }
 
 func createCategoryFolder(categoryName string, parentCategoryPath string) error {
@@ -498,98 +499,55 @@ func createCategoryFolder(categoryName string, parentCategoryPath string) error
 	return nil
 }
 
+// updateYAMLWithNewCategory adds a category at the root or under the given
+// parent path and saves commands.yaml. Names already used anywhere else in
+// the tree are refused so bare-name lookups stay unambiguous.
 func updateYAMLWithNewCategory(categoryName string, parentCategoryName string) error {
-	// Read existing YAML
-	data, err := ioutil.ReadFile("commands.yaml")
-	if err != nil {
-		return err
+	if strings.Contains(categoryName, "/") {
+		return fmt.Errorf("category name %s %w, it cannot contain /", categoryName, errInvalidName)
 	}
 
-	var config Config
-	err = yaml.Unmarshal(data, &config)
-	if err != nil {
-		return err
-	}
-
-	if parentCategoryName == "" {
-		// Check if category already exists at root level
-		for _, category := range config.Categories {
-			if category.Name == categoryName {
-				return fmt.Errorf("category %s already exists", categoryName)
+	return registry().Update(func(config *Config) error {
+		siblings := &config.Categories
+		parentPath := "."
+		if parentCategoryName != "" {
+			parentCategory, err := findParentCategory(parentCategoryName, config.Categories)
+			if err != nil {
+				return err
 			}
-		}
-		newCategory := Category{
-			Name: categoryName,
-			Path: filepath.Join(".", categoryName),
-		}
-		config.Categories = append(config.Categories, newCategory)
-	} else {
-		found := false
-		var queue []*Category
-		for i := range config.Categories {
-			queue = append(queue, &config.Categories[i])
+			siblings = &parentCategory.Subcategories
+			parentPath = parentCategory.Path
 		}
 
-		for len(queue) > 0 {
-			var nextQueue []*Category
-			for _, category := range queue {
-				if category.Name == parentCategoryName {
-					// Check if subcategory already exists
-					for _, subCategory := range category.Subcategories {
-						if subCategory.Name == categoryName {
-							return fmt.Errorf("subcategory %s already exists under %s", categoryName, parentCategoryName)
-						}
-					}
-					newCategory := Category{
-						Name: categoryName,
-						Path: filepath.Join(category.Path, categoryName),
-					}
-					category.Subcategories = append(category.Subcategories, newCategory)
-					found = true
-					break
-				}
-				for i := range category.Subcategories {
-					nextQueue = append(nextQueue, &category.Subcategories[i])
-				}
-			}
-			if found {
-				break
+		// Check if category already exists at this level
+		for _, category := range *siblings {
+			if category.Name == categoryName {
+				return fmt.Errorf("category %s %w", categoryName, errAlreadyExists)
 			}
-			queue = nextQueue
 		}
 
-		if !found {
-			return fmt.Errorf("Parent category not found")
+		matches := findCategoriesByName(categoryName, config.Categories)
+		if len(matches) > 0 {
+			return fmt.Errorf("a category named %s %w at %s, a second one would make %s ambiguous", categoryName, errAlreadyExists, matches[0].path, categoryName)
 		}
-	}
-
-	// Write updated YAML back to file
-	updatedData, err := yaml.Marshal(&config)
-	if err != nil {
-		return err
-	}
-
-	err = ioutil.WriteFile("commands.yaml", updatedData, 0644)
-	if err != nil {
-		return err
-	}
 
-	return nil
+		newCategory := Category{
+			Name:     categoryName,
+			Path:     filepath.Join(parentPath, categoryName),
+			Metadata: categoryMetadata,
+		}
+		*siblings = append(*siblings, newCategory)
+		return nil
+	})
 }
 
+// findCategoryPath returns the folder of the category at the given path.
 func findCategoryPath(categoryName string, categories []Category) (string, error) {
-	for _, category := range categories {
-		if category.Name == categoryName {
-			return category.Path, nil
-		}
-		if len(category.Subcategories) > 0 {
-			path, err := findCategoryPath(categoryName, category.Subcategories)
-			if err == nil {
-				return path, nil
-			}
-		}
+	category, err := findParentCategory(categoryName, categories)
+	if err != nil {
+		return "", err
 	}
-	return "", fmt.Errorf("category not found")
+	return category.Path, nil
 }
 
 func sendPromptToGPT4(prompt string) (string, error) {
@@ -642,14 +600,7 @@ func initializeAutoComplete() {
 	newLinuxCommandCmd.Flags().StringVarP(&scriptContent, "content", "c", "", "Optional content for the .sh file")
 
 	f := func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
-		// Read existing YAML
-		data, err := ioutil.ReadFile("commands.yaml")
-		if err != nil {
-			return nil, cobra.ShellCompDirectiveError
-		}
-
-		var config Config
-		err = yaml.Unmarshal(data, &config)
+		config, err := loadLayeredConfig()
 		if err != nil {
 			return nil, cobra.ShellCompDirectiveError
 		}
@@ -669,112 +620,142 @@ func initializeAutoComplete() {
 	generateLinuxCommandCmd.ValidArgsFunction = f
 }
 
-func executeProgram(program string, args []string) {
-	cmd := exec.Command(program, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err := cmd.Run()
+// executeProgram runs argv, as built by a Runner, with the command's settings
+// and returns the exit code asd should exit with. Output goes to stdout and
+// stderr, or to asd's own when they are nil.
+func executeProgram(argv []string, settings RunSettings, stdout io.Writer, stderr io.Writer) int {
+	program := argv[0]
+	env, err := settings.environment()
 	if err != nil {
-		fmt.Printf("Failed to execute program %s: %s\n", program, err.Error())
-		return
+		fmt.Fprintf(os.Stderr, "Failed to load environment for %s: %s\n", program, err.Error())
+		return exitConfig
+	}
+
+	if isOn(settings.Exec) {
+		err = execProgram(argv, env, settings.Workdir)
+		fmt.Fprintf(os.Stderr, "Failed to exec program %s: %s\n", program, err.Error())
+		return childExitCode(err)
 	}
+
+	cmd := exec.Command(program, argv[1:]...)
+	cmd.Env = env
+	cmd.Dir = settings.Workdir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return superviseProcess(cmd, settings)
 }
 
 func addCommandsToCategory(catCmd *cobra.Command, category Category) {
 	for _, command := range category.Commands {
-		// Construct the full executable path using the extension
-		executablePath := filepath.Join(category.Path, command.Name+command.Extension)
+		command := command
+		// Category paths are relative to the config file, not the working directory
+		dir := commandDir(category, command)
 
 		cmd := &cobra.Command{
-			Use:   command.Name,
-			Short: "Runs the " + command.Name + " executable",
 			Run: func(cmd *cobra.Command, args []string) {
-				fmt.Printf("%s: %s\n", command.Name, executablePath)
-				// Using filepath.Join to ensure the path is correctly formed
-				fullPath := filepath.Join(".", executablePath)
-				executeProgram(fullPath, args)
+				if wantsAsdHelp(cmd, command, args) {
+					cmd.Help()
+					return
+				}
+				if dryRun {
+					args = append(append([]string{}, command.Resolved.DefaultArgs...), args...)
+					err := explainCommand(os.Stdout, commandPathOf(cmd), command, dir, args)
+					if err != nil {
+						fail(codeFor(err), "Error: %s", err)
+					}
+					return
+				}
+				started := time.Now()
+				userArgs := rawCommandArgs(cmd, args)
+				var argv []string
+				defer func() { recordHistory(cmd, userArgs, argv, started, exitCode) }()
+
+				exitCode = runNeeds(commandPathOf(cmd), command, nil, nil)
+				if exitCode != exitOK {
+					return
+				}
+				if !cmd.DisableFlagParsing {
+					args = append(specArgs(cmd, command), args...)
+				}
+				args = append(append([]string{}, command.Resolved.DefaultArgs...), args...)
+				argv = resolvedArgv(command, dir, args)
+				exitCode = runRegisteredCommand(commandPathOf(cmd), command, dir, args, nil, nil)
 			},
 		}
+		defaultShort := "Runs the " + command.Name + " executable"
+		if command.isWorkflow() {
+			defaultShort = "Runs the " + command.Name + " workflow"
+		}
+		applyMetadata(cmd, command.Name, command.Metadata, defaultShort)
+		err := configureFlagParsing(cmd, command)
+		if err == nil {
+			err = applySpec(cmd, command)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %s\n", command.Name, err)
+			continue
+		}
+		if settings := command.Resolved.describe(); len(settings) > 0 {
+			if cmd.Long == "" {
+				cmd.Long = cmd.Short
+			}
+			cmd.Long += "\n\nRuns with:\n  " + strings.Join(settings, "\n  ")
+		}
+		if command.isWorkflow() {
+			if cmd.Long == "" {
+				cmd.Long = cmd.Short
+			}
+			cmd.Long += "\n\nSteps:\n  " + strings.Join(command.describeSteps(), "\n  ")
+		}
 		catCmd.AddCommand(cmd)
 	}
 
 	for _, subcategory := range category.Subcategories {
-		subCatCmd := &cobra.Command{
-			Use:   subcategory.Name,
-			Short: "Commands under " + subcategory.Name,
-		}
+		subCatCmd := newCategoryCommand(subcategory)
 		addCommandsToCategory(subCatCmd, subcategory)
 		catCmd.AddCommand(subCatCmd)
 	}
 }
 
-func createGoFile(path string, commandName string) error {
-	content := []byte(fmt.Sprintf(`package main
-
-import "fmt"
-
-func main() {
-	fmt.Println("Running %s program")
-}
-`, commandName))
-
-	filePath := filepath.Join(path, commandName+".go")
-	return ioutil.WriteFile(filePath, content, 0644)
-}
-
-func addNewCommandToYAML(commandName string, categoryName string, commandType string, categories *[]Category) (string, error) {
+// addNewCommandToYAML adds a command of the given type to category and
+// creates its source file. categoryPath is the full path of category.
+func addNewCommandToYAML(commandName string, category *Category, categoryPath string, commandType string) error {
 	var newCommand Command
 	if commandType == "go" {
 		newCommand = Command{
-			Name:      commandName,
-			Extension: ".exe",
+			Name:   commandName,
+			Runner: runnerGo,
 		}
 	} else if commandType == "linux" {
 		newCommand = Command{
-			Name:      commandName,
-			Extension: ".sh",
+			Name:   commandName,
+			Runner: runnerShell,
 		}
 	} else {
-		print(fmt.Errorf("empty command type for command %s\n", commandName))
+		return fmt.Errorf("empty command type for command %s", commandName)
 	}
+	newCommand.Metadata = commandMetadata
 
-	for i, category := range *categories {
-		if category.Name == categoryName {
-			(*categories)[i].Commands = append((*categories)[i].Commands, newCommand)
-			if commandType == "go" {
-				return category.Path, createGoFile(category.Path, commandName)
-			} else {
-				return category.Path, createShellScript(category.Path, commandName, scriptContent)
-			}
-		}
-
-		if len(category.Subcategories) > 0 {
-			path, err := addNewCommandToYAML(commandName, categoryName, commandType, &(*categories)[i].Subcategories)
-			if err == nil {
-				return path, nil
-			}
-		}
+	category.Commands = append(category.Commands, newCommand)
+	if commandType == "linux" && scriptContent != "" {
+		return createShellScript(resolveCategoryPath(category.Path), commandName, scriptContent)
 	}
-
-	return "", fmt.Errorf("category not found")
+	return scaffoldCommand(resolveCategoryPath(category.Path), newCommand, categoryPath)
 }
 
+// createShellScript writes a script with the content given to
+// new-linux-command.
 func createShellScript(path string, commandName string, content string) error {
-	if content == "" {
-		content = fmt.Sprintf(`#!/bin/bash
-
-echo "Running %s Linux command"
-`, commandName)
-	}
-
 	filePath := filepath.Join(path, commandName+".sh")
 	return ioutil.WriteFile(filePath, []byte(content), 0755)
 }
 
-func compileAllGoFiles(path string) {
+// compileAllGoFiles builds every .go file under path, except the sources in
+// skip, which are compiled on every run.
+func compileAllGoFiles(path string, skip map[string]bool) {
 	files, err := ioutil.ReadDir(path)
 	if err != nil {
-		fmt.Println("Failed to read directory:", err)
+		fail(exitFileSystem, "Failed to read directory: %s", err)
 		return
 	}
 
@@ -783,8 +764,12 @@ func compileAllGoFiles(path string) {
 	for _, file := range files {
 		filePath := filepath.Join(path, file.Name())
 		if file.IsDir() {
-			compileAllGoFiles(filePath)
+			compileAllGoFiles(filePath, skip)
 		} else if strings.HasSuffix(file.Name(), ".go") {
+			if absPath, err := filepath.Abs(filePath); err == nil && skip[absPath] {
+				fmt.Printf("Skipping %s, it is compiled on every run\n", filePath)
+				continue
+			}
 			go compileGoFile(filePath, done)
 			<-done
 		}
@@ -792,10 +777,9 @@ func compileAllGoFiles(path string) {
 }
 
 func compileGoFile(filePath string, done chan bool) {
-	cmd := exec.Command("go", "build", "-o", filePath[:len(filePath)-3]+".exe", filePath)
-	err := cmd.Run()
+	err := buildGoBinary(filePath)
 	if err != nil {
-		fmt.Printf("Failed to compile %s: %s\n", filePath, err)
+		fail(exitFailure, "Failed to compile %s: %s", filePath, err)
 	} else {
 		fmt.Printf("Successfully compiled %s\n", filePath)
 	}
@@ -804,42 +788,59 @@ func compileGoFile(filePath string, done chan bool) {
 	}
 }
 
-func removeCommandFromYAML(commandName string, categoryName string, categories *[]Category) error {
-	for i, category := range *categories {
-		if category.Name == categoryName {
-			for j, cmd := range category.Commands {
-				if cmd.Name == commandName {
-					// Remove the command from the list
-					(*categories)[i].Commands = append((*categories)[i].Commands[:j], (*categories)[i].Commands[j+1:]...)
-					return nil
-				}
-			}
-		}
+// buildGoBinary compiles a command's .go file into the binary next to it.
+func buildGoBinary(filePath string) error {
+	return buildGoBinaryTo(filePath, goArtifactPath(filePath))
+}
 
-		if len(category.Subcategories) > 0 {
-			err := removeCommandFromYAML(commandName, categoryName, &(*categories)[i].Subcategories)
-			if err == nil {
-				return nil
-			}
+// buildGoBinaryTo compiles a command's .go file into output. The build runs
+// in the file's folder, so the module it belongs to is used. A failed build
+// returns the compiler output in the error.
+func buildGoBinaryTo(filePath string, output string) error {
+	filePath, err := filepath.Abs(filePath)
+	if err != nil {
+		return err
+	}
+	output, err = filepath.Abs(output)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("go", "build", "-o", output, filePath)
+	cmd.Dir = filepath.Dir(filePath)
+	combined, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w\n%s", err, strings.TrimSpace(string(combined)))
+	}
+	return nil
+}
+
+func removeCommandFromYAML(commandName string, category *Category) error {
+	for j, cmd := range category.Commands {
+		if cmd.Name == commandName {
+			// Remove the command from the list
+			category.Commands = append(category.Commands[:j], category.Commands[j+1:]...)
+			return nil
 		}
 	}
 
-	return fmt.Errorf("command or category not found")
+	return fmt.Errorf("command %s %w", commandName, errNotFound)
 }
 
+// getAllCategoryNames returns the full path of every category for auto-completion.
 func getAllCategoryNames(config Config) []string {
 	var categories []string
 	for _, category := range config.Categories {
-		categories = append(categories, collectCategories(category)...)
+		categories = append(categories, collectCategories(category, "")...)
 	}
 	return categories
 }
 
-func collectCategories(category Category) []string {
+func collectCategories(category Category, parentPath string) []string {
 	var result []string
-	result = append(result, category.Name)
+	categoryPath := joinCategoryPath(parentPath, category.Name)
+	result = append(result, categoryPath)
 	for _, subcategory := range category.Subcategories {
-		result = append(result, collectCategories(subcategory)...)
+		result = append(result, collectCategories(subcategory, categoryPath)...)
 	}
 	return result
 }
@@ -852,7 +853,10 @@ func listCommandsAndCategories(categoryNames []string, categories []Category) {
 			"new-category\nnew-go-command\n" +
 				"new-linux-command\ncompile\n" +
 				"remove\ngenerate-go-command\n" +
-				"generate-linux-command\nlist")
+				"generate-linux-command\nlist\n" +
+				"migrate\ndoctor\n" +
+				"history\nlast\ngraph\nrun-all\n" +
+				"explain\ntemplates\nnew-command")
 
 		fmt.Println("\nCategories:")
 		for _, category := range categories {
@@ -861,27 +865,29 @@ func listCommandsAndCategories(categoryNames []string, categories []Category) {
 		return
 	}
 
-	currentCategory := categoryNames[0]
-	for _, category := range categories {
-		if category.Name == currentCategory {
-			if len(categoryNames) == 1 {
-				fmt.Println("\nCommands in " + currentCategory + ":")
-				for _, cmd := range category.Commands {
-					fmt.Println("  " + cmd.Name)
-				}
-				if len(category.Subcategories) > 0 {
-					fmt.Println("\nSubcategories in " + currentCategory + ":")
-					for _, subcat := range category.Subcategories {
-						fmt.Println("  " + subcat.Name)
-					}
-				}
-			} else {
-				listCommandsAndCategories(categoryNames[1:], category.Subcategories)
-			}
-			return
+	// Accept both "list ops db" and "list ops/db"
+	categoryPath := strings.Join(categoryNames, "/")
+	category, err := findParentCategory(categoryPath, categories)
+	if err != nil {
+		fail(codeFor(err), "Error: %s", err)
+		return
+	}
+
+	fmt.Println("\nCommands in " + categoryPath + ":")
+	for _, cmd := range category.Commands {
+		if cmd.Layer != "" {
+			fmt.Printf("  %s (%s)\n", cmd.Name, cmd.Layer)
+		} else {
+			fmt.Println("  " + cmd.Name)
 		}
-		if len(category.Subcategories) > 0 {
-			listCommandsAndCategories(categoryNames, category.Subcategories)
+		for _, setting := range cmd.Resolved.describe() {
+			fmt.Println("      " + setting)
+		}
+	}
+	if len(category.Subcategories) > 0 {
+		fmt.Println("\nSubcategories in " + categoryPath + ":")
+		for _, subcat := range category.Subcategories {
+			fmt.Println("  " + subcat.Name)
 		}
 	}
 }
@@ -914,70 +920,104 @@ func detectShell() string {
 }
 
 func Initialize() {
-	// Initialize commands.yaml with a hello-world command
-	config := Config{}
-
-	// Write to commands.yaml
-	data, err := yaml.Marshal(&config)
+	// Initialize commands.yaml with an empty registry
+	err := registry().Save(Config{Version: currentConfigVersion})
 	if err != nil {
-		panic(err)
-	}
-	err = ioutil.WriteFile("commands.yaml", data, 0644)
-	if err != nil {
-		panic(err)
+		fail(exitConfig, "Could not create %s: %s", configPath, err)
+		return
 	}
 
 	err = addToPath()
 	if err != nil {
-		panic(err)
+		fail(exitFailure, "Could not add asd to PATH: %s", err)
+		return
 	}
 
 	fmt.Println("Initialized")
 }
 
-func main() {
-	var rootCmd = &cobra.Command{Use: "asd"}
+// builtinCommands returns the commands asd has besides those from
+// commands.yaml.
+func builtinCommands() []*cobra.Command {
+	return []*cobra.Command{
+		listCmd,
+		newCategoryCmd,
+		newGoCommandCmd,
+		newLinuxCommandCmd,
+		compileCmd,
+		removeCommandCmd,
+		generateGoCommandCmd,
+		generateLinuxCommandCmd,
+		completionCmd,
+		migrateCmd,
+		doctorCmd,
+		historyCmd,
+		lastCmd,
+		graphCmd,
+		runAllCmd,
+		explainCmd,
+		templatesCmd,
+		newCommandCmd,
+	}
+}
 
-	// Check if commands.yaml exists
-	if _, err := os.Stat("commands.yaml"); os.IsNotExist(err) {
-		Initialize()
-		return
+// isBuiltinCommand reports whether name is a built-in command, one of their
+// aliases or cobra's help.
+func isBuiltinCommand(name string) bool {
+	if name == "help" {
+		return true
+	}
+	for _, cmd := range builtinCommands() {
+		if cmd.Name() == name || cmd.HasAlias(name) {
+			return true
+		}
 	}
+	return false
+}
 
-	// Read YAML file
-	data, err := ioutil.ReadFile("commands.yaml")
+func main() {
+	var rootCmd = &cobra.Command{
+		Use:  "asd",
+		Long: "asd runs the commands registered in commands.yaml.\n\n" + exitCodesHelp,
+		// Errors are printed once, below, with the exit code they map to
+		SilenceErrors: true,
+	}
+	rootCmd.PersistentFlags().StringVar(&configFlag, "config", "", "Path to commands.yaml (defaults to ASD_CONFIG, parent directories, then $XDG_CONFIG_HOME/asd)")
+	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "Show what a command from commands.yaml would do instead of running it")
+	rootCmd.PersistentFlags().BoolVar(&noRebuild, "no-rebuild", false, "Run Go commands without rebuilding binaries that are out of date")
+	rootArgs = takeGlobalFlags(os.Args[1:])
+	rootCmd.SetArgs(rootArgs)
+
+	// Find commands.yaml before cobra parses flags, the command tree depends on it
+	path, exists, err := findConfigFile(configFlag)
 	if err != nil {
-		panic(err)
+		fail(exitConfig, "Could not find commands.yaml: %s", err)
+		os.Exit(exitCode)
 	}
+	configPath = path
 
-	var config Config
-	err = yaml.Unmarshal(data, &config)
+	if !exists {
+		Initialize()
+		os.Exit(exitCode)
+	}
+
+	// Merge the global, team, project and user layers into one tree
+	config, err := loadLayeredConfig()
 	if err != nil {
-		panic(err)
+		fail(exitConfig, "Could not load commands: %s", err)
+		os.Exit(exitCode)
 	}
 
+	registeredCommands = indexCommands(config.Categories, "")
 	for _, category := range config.Categories {
-		catCmd := &cobra.Command{
-			Use:   category.Name,
-			Short: "Commands under " + category.Name,
-		}
+		catCmd := newCategoryCommand(category)
 		addCommandsToCategory(catCmd, category)
 		rootCmd.AddCommand(catCmd)
 	}
 
 	initializeAutoComplete()
 
-	rootCmd.AddCommand(
-		listCmd,
-		newCategoryCmd,
-		newGoCommandCmd,
-		newLinuxCommandCmd,
-		compileCmd,
-		removeCommandCmd,
-		generateGoCommandCmd,
-		generateLinuxCommandCmd,
-		completionCmd,
-	)
+	rootCmd.AddCommand(builtinCommands()...)
 
 	// Add shell completion
 	shell := detectShell()
@@ -1004,15 +1044,20 @@ func main() {
 		break
 	default:
 		println("5")
-		fmt.Printf("The shell '%s' can not load auto-completion, continuing without auto-completion.", shell)
+		fmt.Fprintf(os.Stderr, "The shell '%s' can not load auto-completion, continuing without auto-completion.\n", shell)
 		break
 	}
 
-	err = rootCmd.Execute()
+	cmd, err := rootCmd.ExecuteC()
 	if err != nil {
-		fmt.Printf("Failed to execute root command: %s\n", err.Error())
-		return
+		// Cobra has no sentinel for unknown commands, only its message
+		code := exitUsage
+		if strings.HasPrefix(err.Error(), "unknown command") {
+			code = exitNotFound
+		}
+		fail(code, "Error: %s\nRun '%s --help' for usage.", err, cmd.CommandPath())
 	}
+	os.Exit(exitCode)
 }
 
 func runCommand(command string) {
@@ -1022,7 +1067,9 @@ func runCommand(command string) {
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		log.Fatalf("cmd.Run() failed with %s\n", err)
+		// Auto-completion is best effort, never stop the actual command
+		fmt.Fprintf(os.Stderr, "cmd.Run() failed with %s\n", err)
+		return
 	}
 
 	fmt.Printf(" ::: Auto-complete status :::\n%s\n", output)
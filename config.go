@@ -0,0 +1,154 @@
+// config.go
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const configFileName = "commands.yaml"
+
+// configFileNames are the registry files looked for in each directory, in
+// order of preference. The extension selects the storage format.
+var configFileNames = []string{configFileName, "commands.yml", "commands.json", "commands.toml"}
+
+// configPath is the commands.yaml every command reads and writes. It is
+// resolved once in main by findConfigFile.
+var configPath string
+
+// configFlag holds the value of the persistent --config flag.
+var configFlag string
+
+// findConfigFile locates the commands.yaml to use. The lookup order is the
+// --config flag, the ASD_CONFIG environment variable, commands.yaml in the
+// working directory or any of its parents, and finally the user-level file
+// under $XDG_CONFIG_HOME/asd. The returned bool reports whether the file exists.
+func findConfigFile(flagValue string) (string, bool, error) {
+	if flagValue != "" {
+		return checkConfigFile(flagValue)
+	}
+
+	if env := os.Getenv("ASD_CONFIG"); env != "" {
+		return checkConfigFile(env)
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", false, err
+	}
+	for dir := cwd; ; dir = filepath.Dir(dir) {
+		if candidate, ok := findConfigInDir(dir); ok {
+			return candidate, true, nil
+		}
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+
+	userDir, err := userConfigDir()
+	if err == nil {
+		if candidate, ok := findConfigInDir(userDir); ok {
+			return candidate, true, nil
+		}
+	}
+
+	// Nothing found, fall back to initializing in the working directory
+	return filepath.Join(cwd, configFileName), false, nil
+}
+
+// findConfigInDir returns the first registry file from configFileNames that
+// exists in dir.
+func findConfigInDir(dir string) (string, bool) {
+	for _, name := range configFileNames {
+		candidate := filepath.Join(dir, name)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, true
+		}
+	}
+	return filepath.Join(dir, configFileName), false
+}
+
+func checkConfigFile(path string) (string, bool, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", false, err
+	}
+	_, err = os.Stat(absPath)
+	if os.IsNotExist(err) {
+		return absPath, false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return absPath, true, nil
+}
+
+// userConfigDir returns $XDG_CONFIG_HOME/asd, defaulting to ~/.config/asd.
+func userConfigDir() (string, error) {
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "asd"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	if home == "" {
+		return "", errors.New("could not determine home directory")
+	}
+	return filepath.Join(home, ".config", "asd"), nil
+}
+
+// takeGlobalFlags reads --config, --dry-run and --no-rebuild from the raw
+// arguments and returns the arguments without them. The command tree is built
+// from the config file, so --config has to be known before cobra parses it,
+// and passthrough commands would otherwise receive these flags as arguments.
+// Built-in commands are scanned to the end for --config, e.g. asd list
+// --config x.yaml, but their other flags are left to cobra, so asd migrate
+// --dry-run keeps its own meaning. Scanning stops at any other command name
+// and at --, so flags meant for a command from commands.yaml are left alone.
+func takeGlobalFlags(args []string) []string {
+	var rest []string
+	builtin := false
+	i := 0
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			if !builtin && !isBuiltinCommand(arg) {
+				break
+			}
+			builtin = true
+			rest = append(rest, arg)
+			continue
+		}
+		switch {
+		case builtin && arg != "--config" && !strings.HasPrefix(arg, "--config="):
+			rest = append(rest, arg)
+		case arg == "--config" && i+1 < len(args):
+			configFlag = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--config="):
+			configFlag = strings.TrimPrefix(arg, "--config=")
+		case arg == "--dry-run":
+			dryRun = true
+		case arg == "--no-rebuild":
+			noRebuild = true
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return append(rest, args[i:]...)
+}
+
+// resolveCategoryPath makes a category path from commands.yaml relative to
+// the directory holding the config file instead of the working directory.
+func resolveCategoryPath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(configPath), path)
+}
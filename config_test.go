@@ -0,0 +1,68 @@
+// config_test.go
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTakeGlobalFlags(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		want      []string
+		config    string
+		dryRun    bool
+		noRebuild bool
+	}{
+		{
+			name:   "before a command",
+			args:   []string{"--config", "x.yaml", "--dry-run", "--no-rebuild", "tools", "build"},
+			want:   []string{"tools", "build"},
+			config: "x.yaml", dryRun: true, noRebuild: true,
+		},
+		{
+			name: "after a command",
+			args: []string{"tools", "build", "--config=x.yaml", "--dry-run"},
+			want: []string{"tools", "build", "--config=x.yaml", "--dry-run"},
+		},
+		{
+			name:   "config after a built-in",
+			args:   []string{"list", "--config=x.yaml"},
+			want:   []string{"list"},
+			config: "x.yaml",
+		},
+		{
+			name:   "built-in keeps its own flags",
+			args:   []string{"migrate", "--config", "x.yaml", "--dry-run"},
+			want:   []string{"migrate", "--dry-run"},
+			config: "x.yaml",
+		},
+		{
+			name: "built-in keeps inherited switches for cobra",
+			args: []string{"run-all", "ops", "--no-rebuild"},
+			want: []string{"run-all", "ops", "--no-rebuild"},
+		},
+		{
+			name:   "stops at --",
+			args:   []string{"--dry-run", "--", "--config", "x.yaml"},
+			want:   []string{"--", "--config", "x.yaml"},
+			dryRun: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configFlag, dryRun, noRebuild = "", false, false
+			t.Cleanup(func() { configFlag, dryRun, noRebuild = "", false, false })
+
+			got := takeGlobalFlags(test.args)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got args %q, want %q", got, test.want)
+			}
+			if configFlag != test.config || dryRun != test.dryRun || noRebuild != test.noRebuild {
+				t.Errorf("got config %q, dry run %t, no rebuild %t, want %q, %t, %t", configFlag, dryRun, noRebuild, test.config, test.dryRun, test.noRebuild)
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+// doctor.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Kinds of problems reported by asd doctor.
+const (
+	issueMissingFolder  = "missing-folder"
+	issueMissingSource  = "missing-source"
+	issueMissingBinary  = "missing-binary"
+	issueStaleBinary    = "stale-binary"
+	issueOrphanFile     = "orphan-file"
+	issueDuplicateName  = "duplicate-name"
+	issueNotExecutable  = "not-executable"
+	issueUnreadableFile = "unreadable"
+	issueUnknownRunner  = "unknown-runner"
+	issueCheckFailed    = "check-failed"
+)
+
+// DoctorIssue is a single inconsistency between the registry and the filesystem.
+type DoctorIssue struct {
+	Kind     string `json:"kind"`
+	Layer    string `json:"layer"`
+	Category string `json:"category"`
+	Command  string `json:"command,omitempty"`
+	Path     string `json:"path"`
+	Message  string `json:"message"`
+	Fixable  bool   `json:"fixable"`
+	Fixed    bool   `json:"fixed"`
+	FixError string `json:"fix_error,omitempty"`
+}
+
+var doctorFix bool
+var doctorJSON bool
+var doctorCheck bool
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Checks that commands.yaml and the category folders agree",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var issues []DoctorIssue
+		seen := make(map[string]bool)
+		for _, layer := range configLayers() {
+			absPath, err := filepath.Abs(layer.Path)
+			if err != nil || seen[absPath] {
+				continue
+			}
+			seen[absPath] = true
+
+			config, err := newRegistryStore(absPath).Load()
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				issues = append(issues, DoctorIssue{
+					Kind:    issueUnreadableFile,
+					Layer:   layer.Name,
+					Path:    absPath,
+					Message: err.Error(),
+				})
+				continue
+			}
+
+			annotateCategories(config.Categories, layer.Name, filepath.Dir(absPath))
+			issues = append(issues, checkCategories(config.Categories, "")...)
+		}
+
+		if doctorFix {
+			for i := range issues {
+				fixIssue(&issues[i])
+			}
+		}
+
+		if doctorJSON {
+			if issues == nil {
+				issues = []DoctorIssue{}
+			}
+			data, err := json.MarshalIndent(issues, "", "  ")
+			if err != nil {
+				fail(exitFailure, "Failed to encode report: %s", err)
+				return
+			}
+			fmt.Println(string(data))
+		} else {
+			printDoctorReport(issues)
+		}
+
+		// Unfixed problems make doctor fail, so it can gate CI
+		for _, issue := range issues {
+			if !issue.Fixed {
+				exitCode = exitFailure
+				return
+			}
+		}
+	},
+}
+
+func init() {
+	doctorCmd.Flags().BoolVar(&doctorFix, "fix", false, "Repair safe problems: create missing folders, rebuild binaries and mark scripts executable")
+	doctorCmd.Flags().BoolVar(&doctorJSON, "json", false, "Print the report as JSON")
+	doctorCmd.Flags().BoolVar(&doctorCheck, "check", false, "Also check every source with its language's checker, e.g. bash -n")
+}
+
+// checkCategories walks the category tree and compares every command with the
+// files in its category folder.
+func checkCategories(categories []Category, parentPath string) []DoctorIssue {
+	var issues []DoctorIssue
+
+	names := make(map[string]bool)
+	for _, category := range categories {
+		categoryPath := joinCategoryPath(parentPath, category.Name)
+		if names[category.Name] {
+			issues = append(issues, DoctorIssue{
+				Kind:     issueDuplicateName,
+				Layer:    category.Layer,
+				Category: categoryPath,
+				Path:     category.Dir,
+				Message:  fmt.Sprintf("category %s is defined more than once", categoryPath),
+			})
+		}
+		names[category.Name] = true
+
+		issues = append(issues, checkCategory(category, categoryPath)...)
+		issues = append(issues, checkCategories(category.Subcategories, categoryPath)...)
+	}
+
+	return issues
+}
+
+func checkCategory(category Category, categoryPath string) []DoctorIssue {
+	var issues []DoctorIssue
+	newIssue := func(kind string, command string, path string, message string, fixable bool) {
+		issues = append(issues, DoctorIssue{
+			Kind:     kind,
+			Layer:    category.Layer,
+			Category: categoryPath,
+			Command:  command,
+			Path:     path,
+			Message:  message,
+			Fixable:  fixable,
+		})
+	}
+
+	if info, err := os.Stat(category.Dir); err != nil || !info.IsDir() {
+		newIssue(issueMissingFolder, "", category.Dir, fmt.Sprintf("folder for category %s does not exist", categoryPath), true)
+		return issues
+	}
+
+	// Files that belong to a registered command
+	owned := make(map[string]bool)
+	names := make(map[string]bool)
+	for _, subcategory := range category.Subcategories {
+		names[subcategory.Name] = true
+	}
+
+	for _, command := range category.Commands {
+		if names[command.Name] {
+			newIssue(issueDuplicateName, command.Name, category.Dir, fmt.Sprintf("%s is registered more than once in %s", command.Name, categoryPath), false)
+		}
+		names[command.Name] = true
+
+		// Workflows only run other commands and have no files
+		if command.isWorkflow() {
+			continue
+		}
+
+		runner, err := runnerFor(command)
+		if err != nil {
+			newIssue(issueUnknownRunner, command.Name, category.Dir, err.Error(), false)
+			continue
+		}
+		source := runner.Source(category.Dir, command)
+		artifact := runner.Artifact(category.Dir, command)
+		owned[filepath.Base(source)] = true
+		owned[filepath.Base(artifact)] = true
+
+		sourceInfo, sourceErr := os.Stat(source)
+		if sourceErr != nil {
+			newIssue(issueMissingSource, command.Name, source, fmt.Sprintf("%s source is missing", runner.Kind), false)
+		} else if doctorCheck {
+			if err := checkSource(runner.Kind, source); err != nil {
+				newIssue(issueCheckFailed, command.Name, source, err.Error(), false)
+			}
+		}
+
+		if runner.Builds(command) {
+			if _, artifactErr := os.Stat(artifact); artifactErr != nil {
+				newIssue(issueMissingBinary, command.Name, artifact, "binary has not been compiled", sourceErr == nil)
+			} else if sourceErr == nil {
+				if reason := goBinaryStale(source); reason != "" {
+					newIssue(issueStaleBinary, command.Name, artifact, reason, true)
+				}
+			}
+		} else if runner.Executable && sourceErr == nil && runtime.GOOS != "windows" && sourceInfo.Mode()&0111 == 0 {
+			newIssue(issueNotExecutable, command.Name, source, fmt.Sprintf("%s is not executable", filepath.Base(source)), true)
+		}
+	}
+
+	files, err := ioutil.ReadDir(category.Dir)
+	if err != nil {
+		newIssue(issueUnreadableFile, "", category.Dir, err.Error(), false)
+		return issues
+	}
+	for _, file := range files {
+		if file.IsDir() || owned[file.Name()] {
+			continue
+		}
+		if isCommandSourceFile(file.Name()) {
+			newIssue(issueOrphanFile, "", filepath.Join(category.Dir, file.Name()), fmt.Sprintf("%s is not registered in %s", file.Name(), categoryPath), false)
+		}
+	}
+
+	return issues
+}
+
+// fixIssue applies the safe repair for an issue, if there is one.
+func fixIssue(issue *DoctorIssue) {
+	if !issue.Fixable {
+		return
+	}
+
+	var err error
+	switch issue.Kind {
+	case issueMissingFolder:
+		err = os.MkdirAll(issue.Path, 0755)
+	case issueMissingBinary, issueStaleBinary:
+		err = buildGoBinary(goSourcePath(issue.Path))
+	case issueNotExecutable:
+		err = os.Chmod(issue.Path, 0755)
+	default:
+		return
+	}
+
+	if err != nil {
+		issue.FixError = err.Error()
+		return
+	}
+	issue.Fixed = true
+}
+
+func printDoctorReport(issues []DoctorIssue) {
+	if len(issues) == 0 {
+		fmt.Println("No problems found")
+		return
+	}
+
+	remaining := 0
+	for _, issue := range issues {
+		status := ""
+		if issue.Fixed {
+			status = " (fixed)"
+		} else if issue.FixError != "" {
+			status = " (fix failed: " + issue.FixError + ")"
+		} else if issue.Fixable {
+			status = " (fixable with --fix)"
+		}
+		if !issue.Fixed {
+			remaining++
+		}
+
+		subject := issue.Category
+		if issue.Command != "" {
+			subject = joinCategoryPath(issue.Category, issue.Command)
+		}
+		fmt.Printf("[%s] %s %s: %s%s\n", issue.Layer, issue.Kind, subject, issue.Message, status)
+		fmt.Printf("    %s\n", issue.Path)
+	}
+
+	fmt.Printf("\n%d problem(s) found, %d remaining\n", len(issues), remaining)
+}
+
+func joinCategoryPath(parentPath string, name string) string {
+	if parentPath == "" {
+		return name
+	}
+	return parentPath + "/" + name
+}
+
+// isCommandSourceFile reports whether a file looks like it belongs to a
+// command, judging by the source extensions of the known runners.
+func isCommandSourceFile(name string) bool {
+	ext := filepath.Ext(name)
+	if ext == ".exe" {
+		return true
+	}
+	for _, runner := range runners {
+		if runner.SourceExt != "" && ext == runner.SourceExt {
+			return true
+		}
+	}
+	return false
+}
+
+// goSourcePath is the inverse of goArtifactPath.
+func goSourcePath(binary string) string {
+	return strings.TrimSuffix(binary, ".exe") + ".go"
+}
@@ -0,0 +1,106 @@
+// exitcodes.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Exit codes of asd itself. Commands from commands.yaml instead exit with
+// the exit code of the program they ran, or 128+N when it was killed by
+// signal N, so asd can be used transparently in scripts.
+const (
+	// exitOK means the command succeeded.
+	exitOK = 0
+	// exitFailure means a built-in command failed, e.g. a compile error or
+	// doctor finding problems, or a Go command could not be rebuilt.
+	exitFailure = 1
+	// exitUsage means the arguments or flags were invalid.
+	exitUsage = 2
+	// exitConfig means commands.yaml could not be found, read, parsed or written.
+	exitConfig = 3
+	// exitNotFound means a category or command does not exist.
+	exitNotFound = 4
+	// exitFileSystem means creating or removing a command's files failed.
+	exitFileSystem = 5
+	// exitTimeout means the program was stopped because it exceeded its timeout.
+	exitTimeout = 124
+	// exitCannotRun means the program exists but could not be started.
+	exitCannotRun = 126
+	// exitProgramMissing means the program to run does not exist.
+	exitProgramMissing = 127
+)
+
+// exitCodesHelp documents the exit codes in asd --help.
+const exitCodesHelp = `Exit codes:
+  0    success
+  1    a built-in command failed, or a Go command could not be rebuilt
+  2    invalid arguments or flags
+  3    commands.yaml could not be found, read, parsed or written
+  4    category or command not found
+  5    creating or removing command files failed
+  124  the program exceeded its timeout
+  126  the program could not be started
+  127  the program does not exist
+Commands from commands.yaml exit with the code of the program they run,
+or 128+N when it was killed by signal N.`
+
+// exitCode is the status asd exits with once cobra has finished.
+var exitCode = exitOK
+
+// fail prints an error from a built-in command to stderr and records the
+// exit code. The first failure wins.
+func fail(code int, format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	if exitCode == exitOK {
+		exitCode = code
+	}
+}
+
+// Sentinel errors wrapped by registry lookups and updates, so codeFor can
+// tell them apart.
+var (
+	errNotFound      = errors.New("not found")
+	errAmbiguous     = errors.New("is ambiguous")
+	errAlreadyExists = errors.New("already exists")
+	errInvalidName   = errors.New("is not a valid name")
+	errWrongRunner   = errors.New("does not fit the command's runner")
+)
+
+// codeFor picks the exit code for an error returned by a registry lookup or update.
+func codeFor(err error) int {
+	switch {
+	case errors.Is(err, errNotFound):
+		return exitNotFound
+	case errors.Is(err, errAmbiguous), errors.Is(err, errAlreadyExists), errors.Is(err, errInvalidName),
+		errors.Is(err, errWrongRunner):
+		return exitUsage
+	default:
+		return exitConfig
+	}
+}
+
+// childExitCode converts the error from running a program into the exit
+// code asd should pass on.
+func childExitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return exitErr.ExitCode()
+	}
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return exitProgramMissing
+	}
+	return exitCannotRun
+}
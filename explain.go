@@ -0,0 +1,264 @@
+// explain.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// dryRun is set by the global --dry-run flag. Commands from commands.yaml
+// then only explain what they would do.
+var dryRun bool
+
+var explainCmd = &cobra.Command{
+	Use:   "explain [commandPath] [-- args...]",
+	Short: "Shows what running a command would do, without running it",
+	Long: "Shows what running a command would do, without running it: the executable, the\n" +
+		"final argv, the environment changes, the workdir, prerequisites and whether a\n" +
+		"compile would be needed. The command can be given as a path, e.g. ops/deploy, or\n" +
+		"as words, e.g. ops deploy. Arguments after -- are explained as the command's arguments.",
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var commandArgs []string
+		if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+			commandArgs = args[dash:]
+			args = args[:dash]
+		}
+		if len(args) == 0 {
+			fail(exitUsage, "Error: no command given")
+			return
+		}
+
+		path := strings.Trim(strings.Join(args, "/"), "/")
+		target, ok := registeredCommands[path]
+		if !ok {
+			fail(exitNotFound, "Error: command %s %s", path, errNotFound)
+			return
+		}
+		commandArgs = append(append([]string{}, target.Command.Resolved.DefaultArgs...), commandArgs...)
+		err := explainCommand(os.Stdout, path, target.Command, target.Dir, commandArgs)
+		if err != nil {
+			fail(codeFor(err), "Error: %s", err)
+		}
+	},
+}
+
+// explainCommand prints what running command with args would do. Nothing is
+// run or built.
+func explainCommand(w io.Writer, path string, command Command, dir string, args []string) error {
+	return explainAt(w, path, command, dir, args, "", make(map[string]bool))
+}
+
+func explainAt(w io.Writer, path string, command Command, dir string, args []string, indent string, active map[string]bool) error {
+	// An empty label continues the previous line's list
+	line := func(label string, value string) {
+		if label != "" {
+			label += ":"
+		}
+		fmt.Fprintf(w, "%s  %-12s%s\n", indent, label, value)
+	}
+
+	if command.isWorkflow() {
+		fmt.Fprintf(w, "%s%s (workflow)\n", indent, path)
+	} else {
+		fmt.Fprintf(w, "%s%s\n", indent, path)
+	}
+
+	stages, err := planNeeds(path, command)
+	if err != nil {
+		return err
+	}
+	for i, stage := range stages {
+		label := ""
+		if i == 0 {
+			label = "needs"
+		}
+		line(label, fmt.Sprintf("%d. %s", i+1, strings.Join(stage, ", ")))
+	}
+
+	describeHooks := func() {
+		before, after := command.ResolvedHooks.describe()
+		for i, h := range before {
+			label := ""
+			if i == 0 {
+				label = "before"
+			}
+			line(label, h)
+		}
+		for i, h := range after {
+			label := ""
+			if i == 0 {
+				label = "after"
+			}
+			line(label, h)
+		}
+	}
+
+	if command.isWorkflow() {
+		describeHooks()
+		active[path] = true
+		defer delete(active, path)
+		for i, step := range command.Steps {
+			stepPath := strings.Trim(step.Command, "/")
+			fmt.Fprintf(w, "%s  step %d/%d%s\n", indent, i+1, len(command.Steps), stepFlags(step))
+			target, ok := registeredCommands[stepPath]
+			if !ok {
+				return fmt.Errorf("step %d of %s runs %s, command %w", i+1, path, stepPath, errNotFound)
+			}
+			if active[stepPath] {
+				return fmt.Errorf("workflow %s runs itself", stepPath)
+			}
+			// The output of the previous step is only known once it has run
+			stepCommand := target.Command
+			stepCommand.Resolved = stepSettings(stepCommand.Resolved, step, "{prev}")
+			stepArgs := append(append([]string{}, stepCommand.Resolved.DefaultArgs...), expandStepArgs(step.Args, args, "{prev}")...)
+			err := explainAt(w, stepPath, stepCommand, target.Dir, stepArgs, indent+"    ", active)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	runner, err := runnerFor(command)
+	if err != nil {
+		return err
+	}
+	argv, err := runner.Invocation(dir, command, args)
+	if err != nil {
+		return err
+	}
+
+	line("runner", runner.Kind)
+	if runner.Kind == runnerGo {
+		if runner.Builds(command) {
+			line("mode", goModeBuild+", runs the binary built from the source")
+		} else {
+			line("mode", goModeRun+", compiles the source in its module on every invocation, like go run")
+		}
+	}
+	if runner.Kind != runnerExec {
+		line("source", runner.Source(dir, command))
+	}
+	line("executable", runner.Artifact(dir, command))
+	if runner.Builds(command) {
+		line("compile", compileStatus(runner.Source(dir, command), rebuildEnabled(command.Resolved)))
+	}
+	line("argv", quoteArgv(argv))
+
+	settings := command.Resolved
+	if settings.Workdir != "" {
+		line("workdir", settings.Workdir)
+	} else {
+		cwd, _ := os.Getwd()
+		line("workdir", cwd+" (current directory)")
+	}
+
+	changes, err := environmentChanges(settings)
+	if err != nil {
+		line("env", "could not load env_file: "+err.Error())
+	}
+	for i, change := range changes {
+		label := ""
+		if i == 0 {
+			label = "env"
+		}
+		line(label, change)
+	}
+
+	describeHooks()
+
+	if settings.Timeout != "" {
+		grace := settings.KillGrace
+		if grace == "" {
+			grace = defaultKillGrace.String()
+		}
+		line("timeout", settings.Timeout+", killed "+grace+" later")
+	}
+	if isOn(settings.Exec) {
+		line("exec", "replaces the asd process")
+	}
+	if isOn(settings.TTY) {
+		line("tty", "runs under a pseudo-terminal")
+	}
+	return nil
+}
+
+func stepFlags(step Step) string {
+	if step.ContinueOnError {
+		return " (continue on error)"
+	}
+	return ""
+}
+
+// compileStatus describes whether running a Go command would rebuild it.
+func compileStatus(source string, rebuild bool) string {
+	if _, err := os.Stat(source); err != nil {
+		return "source is missing"
+	}
+	reason := goBinaryStale(source)
+	switch {
+	case reason == "":
+		return "not needed, binary is up to date"
+	case rebuild:
+		return "would rebuild first, " + reason
+	default:
+		return "rebuild disabled, " + reason
+	}
+}
+
+// environmentChanges lists the variables the command's settings add to or
+// change in asd's own environment.
+func environmentChanges(settings RunSettings) ([]string, error) {
+	current := make(map[string]string)
+	for _, entry := range os.Environ() {
+		key, value, _ := strings.Cut(entry, "=")
+		current[key] = value
+	}
+
+	// Without the env file, env is still worth showing
+	env, err := settings.environment()
+	if err != nil {
+		settings.EnvFile = ""
+		env, _ = settings.environment()
+	}
+
+	final := make(map[string]string)
+	var order []string
+	for _, entry := range env[len(os.Environ()):] {
+		key, value, _ := strings.Cut(entry, "=")
+		if _, seen := final[key]; !seen {
+			order = append(order, key)
+		}
+		final[key] = value
+	}
+
+	var changes []string
+	for _, key := range order {
+		old, existed := current[key]
+		switch {
+		case !existed:
+			changes = append(changes, "+ "+key+"="+final[key])
+		case old != final[key]:
+			changes = append(changes, "~ "+key+"="+final[key]+" (was "+old+")")
+		}
+	}
+	return changes, err
+}
+
+// quoteArgv joins argv so it can be pasted into a shell.
+func quoteArgv(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$`*?;&|<>(){}[]#~") {
+			quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		} else {
+			quoted[i] = arg
+		}
+	}
+	return strings.Join(quoted, " ")
+}
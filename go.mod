@@ -3,7 +3,11 @@ module asd
 go 1.21
 
 require (
+	github.com/BurntSushi/toml v1.3.2
+	github.com/creack/pty v1.1.21
 	github.com/spf13/cobra v1.7.0
+	golang.org/x/sys v0.15.0
+	golang.org/x/term v0.15.0
 	gopkg.in/yaml.v2 v2.4.0
 )
 
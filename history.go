@@ -0,0 +1,382 @@
+// history.go
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// historyFileName is the append-only log of command invocations, one JSON
+// object per line.
+const historyFileName = "history.jsonl"
+
+// HistoryEntry records a single run of a command from commands.yaml. Args are
+// the arguments as typed, before asd parsed any flags, Argv is the resolved
+// program and arguments that ran. Workflows have no Argv.
+type HistoryEntry struct {
+	Time     time.Time `json:"time"`
+	Command  string    `json:"command"`
+	Args     []string  `json:"args"`
+	Argv     []string  `json:"argv,omitempty"`
+	Cwd      string    `json:"cwd"`
+	Duration int64     `json:"duration_ms"`
+	ExitCode int       `json:"exit_code"`
+	User     string    `json:"user"`
+}
+
+// historyPath returns ASD_HISTORY if set, otherwise history.jsonl under
+// $XDG_STATE_HOME/asd, defaulting to ~/.local/state/asd.
+func historyPath() (string, error) {
+	if path := os.Getenv("ASD_HISTORY"); path != "" {
+		return path, nil
+	}
+	if xdg := os.Getenv("XDG_STATE_HOME"); xdg != "" {
+		return filepath.Join(xdg, "asd", historyFileName), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	if home == "" {
+		return "", errors.New("could not determine home directory")
+	}
+	return filepath.Join(home, ".local", "state", "asd", historyFileName), nil
+}
+
+// commandPathOf returns the slash separated path of a generated command,
+// e.g. "ops/db/backup" for "asd ops db backup".
+func commandPathOf(cmd *cobra.Command) string {
+	parts := strings.Fields(cmd.CommandPath())
+	return strings.Join(parts[1:], "/")
+}
+
+// rootArgs are the arguments cobra parses, without the global flags read
+// before the config was loaded.
+var rootArgs []string
+
+// rawCommandArgs returns the arguments given after cmd's path as they were
+// typed. Commands with managed flags only see what cobra left after parsing
+// them, which asd last could not replay.
+func rawCommandArgs(cmd *cobra.Command, parsed []string) []string {
+	if cmd.DisableFlagParsing {
+		return parsed
+	}
+	depth := len(strings.Fields(cmd.CommandPath())) - 1
+	if depth > len(rootArgs) {
+		return parsed
+	}
+	for _, arg := range rootArgs[:depth] {
+		if strings.HasPrefix(arg, "-") {
+			return parsed
+		}
+	}
+	return rootArgs[depth:]
+}
+
+// resolvedArgv returns the program and arguments running command with args
+// executes, or nil for workflows and broken commands.
+func resolvedArgv(command Command, dir string, args []string) []string {
+	if command.isWorkflow() {
+		return nil
+	}
+	runner, err := runnerFor(command)
+	if err != nil {
+		return nil
+	}
+	argv, err := runner.Invocation(dir, command, args)
+	if err != nil {
+		return nil
+	}
+	return argv
+}
+
+// recordHistory appends a run of cmd to the history log. History is best
+// effort, a failure to write it never changes the command's exit code.
+func recordHistory(cmd *cobra.Command, args []string, argv []string, started time.Time, code int) {
+	entry := HistoryEntry{
+		Time:     started,
+		Command:  commandPathOf(cmd),
+		Args:     args,
+		Argv:     argv,
+		Duration: time.Since(started).Milliseconds(),
+		ExitCode: code,
+		User:     currentUserName(),
+	}
+	if entry.Args == nil {
+		entry.Args = []string{}
+	}
+	entry.Cwd, _ = os.Getwd()
+
+	err := appendHistory(entry)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not record history: %s\n", err)
+	}
+}
+
+func appendHistory(entry HistoryEntry) error {
+	path, err := historyPath()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	// A single write of one line with O_APPEND keeps concurrent runs from
+	// interleaving their entries
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(data, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// readHistory returns every entry in the log, oldest first. Lines that can't
+// be parsed are skipped.
+func readHistory() ([]HistoryEntry, error) {
+	path, err := historyPath()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry HistoryEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func currentUserName() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return os.Getenv("USERNAME")
+}
+
+// historyFilter selects entries for asd history.
+type historyFilter struct {
+	Command  string
+	Category string
+	Since    time.Time
+	Until    time.Time
+	Status   string
+}
+
+func (f historyFilter) matches(entry HistoryEntry) bool {
+	if f.Command != "" && entry.Command != f.Command && !strings.HasSuffix(entry.Command, "/"+f.Command) {
+		return false
+	}
+	if f.Category != "" && !strings.HasPrefix(entry.Command, strings.Trim(f.Category, "/")+"/") {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && entry.Time.After(f.Until) {
+		return false
+	}
+	switch f.Status {
+	case "":
+	case "ok":
+		return entry.ExitCode == exitOK
+	case "failed":
+		return entry.ExitCode != exitOK
+	default:
+		code, _ := strconv.Atoi(f.Status)
+		return entry.ExitCode == code
+	}
+	return true
+}
+
+// parseHistoryTime accepts a duration back from now, e.g. 2h, or an absolute
+// date or time.
+func parseHistoryTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected a duration like 2h or a date like 2006-01-02", value)
+}
+
+func validateHistoryStatus(status string) error {
+	if status == "" || status == "ok" || status == "failed" {
+		return nil
+	}
+	if _, err := strconv.Atoi(status); err != nil {
+		return fmt.Errorf("invalid status %q, expected ok, failed or an exit code", status)
+	}
+	return nil
+}
+
+var historyCommand string
+var historyCategory string
+var historySince string
+var historyUntil string
+var historyStatus string
+var historyLimit int
+var historyJSON bool
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Shows which commands ran, when, with which arguments and result",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		since, err := parseHistoryTime(historySince)
+		if err != nil {
+			fail(exitUsage, "Error: %s", err)
+			return
+		}
+		until, err := parseHistoryTime(historyUntil)
+		if err != nil {
+			fail(exitUsage, "Error: %s", err)
+			return
+		}
+		err = validateHistoryStatus(historyStatus)
+		if err != nil {
+			fail(exitUsage, "Error: %s", err)
+			return
+		}
+		filter := historyFilter{
+			Command:  historyCommand,
+			Category: historyCategory,
+			Since:    since,
+			Until:    until,
+			Status:   historyStatus,
+		}
+
+		entries, err := readHistory()
+		if err != nil {
+			fail(exitFailure, "Could not read history: %s", err)
+			return
+		}
+		var matched []HistoryEntry
+		for _, entry := range entries {
+			if filter.matches(entry) {
+				matched = append(matched, entry)
+			}
+		}
+		if historyLimit > 0 && len(matched) > historyLimit {
+			matched = matched[len(matched)-historyLimit:]
+		}
+
+		if historyJSON {
+			if matched == nil {
+				matched = []HistoryEntry{}
+			}
+			data, err := json.MarshalIndent(matched, "", "  ")
+			if err != nil {
+				fail(exitFailure, "Failed to encode history: %s", err)
+				return
+			}
+			fmt.Println(string(data))
+			return
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "TIME\tEXIT\tDURATION\tUSER\tCOMMAND")
+		for _, entry := range matched {
+			invocation := strings.Join(append([]string{entry.Command}, entry.Args...), " ")
+			fmt.Fprintf(writer, "%s\t%d\t%s\t%s\t%s\n",
+				entry.Time.Local().Format("2006-01-02 15:04:05"),
+				entry.ExitCode,
+				time.Duration(entry.Duration)*time.Millisecond,
+				entry.User,
+				invocation)
+		}
+		writer.Flush()
+	},
+}
+
+var lastCmd = &cobra.Command{
+	Use:   "last",
+	Short: "Runs the most recent command again, with the same arguments and working directory",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		entries, err := readHistory()
+		if err != nil {
+			fail(exitFailure, "Could not read history: %s", err)
+			return
+		}
+		if len(entries) == 0 {
+			fail(exitNotFound, "No commands have been run yet")
+			return
+		}
+		entry := entries[len(entries)-1]
+
+		target, _, err := cmd.Root().Find(strings.Split(entry.Command, "/"))
+		if err != nil || target.Run == nil || isCategoryCommand(target) || commandPathOf(target) != entry.Command {
+			fail(exitNotFound, "Command %s no longer exists", entry.Command)
+			return
+		}
+		if entry.Cwd != "" {
+			err = os.Chdir(entry.Cwd)
+			if err != nil {
+				fail(exitFileSystem, "Could not change to %s: %s", entry.Cwd, err)
+				return
+			}
+		}
+
+		// Replay through cobra, so managed flags are parsed again
+		fmt.Fprintf(os.Stderr, "Running %s\n", strings.Join(append([]string{entry.Command}, entry.Args...), " "))
+		rootArgs = append(strings.Split(entry.Command, "/"), entry.Args...)
+		cmd.Root().SetArgs(rootArgs)
+		err = cmd.Root().Execute()
+		if err != nil {
+			fail(exitUsage, "Could not run %s again: %s", entry.Command, err)
+		}
+	},
+}
+
+func init() {
+	historyCmd.Flags().StringVar(&historyCommand, "command", "", "Only show runs of this command, by name or path")
+	historyCmd.Flags().StringVar(&historyCategory, "category", "", "Only show commands in this category path")
+	historyCmd.Flags().StringVar(&historySince, "since", "", "Only show runs after this time, a duration like 24h or a date like 2006-01-02")
+	historyCmd.Flags().StringVar(&historyUntil, "until", "", "Only show runs before this time, a duration like 24h or a date like 2006-01-02")
+	historyCmd.Flags().StringVar(&historyStatus, "status", "", "Only show runs with this result: ok, failed or an exit code")
+	historyCmd.Flags().IntVarP(&historyLimit, "limit", "n", 20, "Show at most this many runs, 0 shows all")
+	historyCmd.Flags().BoolVar(&historyJSON, "json", false, "Print the history as JSON")
+}
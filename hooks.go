@@ -0,0 +1,162 @@
+// hooks.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// Hooks are shell commands run around every command of a category and its
+// subcategories, or of the whole registry when set at the root of
+// commands.yaml. Hooks get the command path and arguments in ASD_COMMAND and
+// ASD_ARGS, after hooks also ASD_EXIT_CODE and ASD_DURATION_MS.
+type Hooks struct {
+	Before []string `yaml:"before,omitempty" json:"before,omitempty" toml:"before,omitempty"`
+	After  []string `yaml:"after,omitempty" json:"after,omitempty" toml:"after,omitempty"`
+}
+
+// hook is a single hook with the directory of the config file that defined
+// it, which it runs in so relative script paths work.
+type hook struct {
+	Run string
+	Dir string
+}
+
+// hookChain holds the hooks that apply to a command. Before hooks run from
+// the outermost level inwards, after hooks from the innermost level outwards.
+type hookChain struct {
+	Before []hook
+	After  []hook
+}
+
+// wrap returns the chain with hooks defined one level further in.
+func (c hookChain) wrap(hooks Hooks, dir string) hookChain {
+	var result hookChain
+	result.Before = append(result.Before, c.Before...)
+	for _, run := range hooks.Before {
+		result.Before = append(result.Before, hook{Run: run, Dir: dir})
+	}
+	for _, run := range hooks.After {
+		result.After = append(result.After, hook{Run: run, Dir: dir})
+	}
+	result.After = append(result.After, c.After...)
+	return result
+}
+
+// nest returns the chain with inner's hooks one level further in.
+func (c hookChain) nest(inner hookChain) hookChain {
+	var result hookChain
+	result.Before = append(append(result.Before, c.Before...), inner.Before...)
+	result.After = append(append(result.After, inner.After...), c.After...)
+	return result
+}
+
+// applyRootHooks puts the root level hooks of every layer around the hooks of
+// each command.
+func applyRootHooks(categories []Category, root hookChain) {
+	for i := range categories {
+		category := &categories[i]
+		for j := range category.Commands {
+			command := &category.Commands[j]
+			command.ResolvedHooks = root.nest(command.ResolvedHooks)
+		}
+		applyRootHooks(category.Subcategories, root)
+	}
+}
+
+// hookRun describes the command a hook runs around.
+type hookRun struct {
+	Path     string
+	Args     []string
+	Env      []string
+	ExitCode int
+	Duration time.Duration
+}
+
+// runHook runs a single hook and returns its exit code. Hook output goes to
+// stderr, so the command's stdout stays clean for pipes.
+func runHook(h hook, stage string, run hookRun, stderr io.Writer) int {
+	argv := hookShell(h.Run)
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Dir = h.Dir
+	cmd.Stdout = stderr
+	cmd.Stderr = stderr
+	cmd.Env = append(append([]string{}, run.Env...),
+		"ASD_HOOK="+stage,
+		"ASD_COMMAND="+run.Path,
+		"ASD_ARGS="+quoteArgv(run.Args),
+	)
+	if stage == "after" {
+		cmd.Env = append(cmd.Env,
+			"ASD_EXIT_CODE="+strconv.Itoa(run.ExitCode),
+			"ASD_DURATION_MS="+strconv.FormatInt(run.Duration.Milliseconds(), 10),
+		)
+	}
+	return childExitCode(cmd.Run())
+}
+
+// runBeforeHooks runs the before hooks in order and stops at the first that
+// fails. It returns that hook's exit code, or exitOK.
+func runBeforeHooks(chain hookChain, run hookRun, stderr io.Writer) int {
+	for _, h := range chain.Before {
+		code := runHook(h, "before", run, stderr)
+		if code != exitOK {
+			fmt.Fprintf(stderr, "Before hook %q failed with exit code %d, not running %s\n", h.Run, code, run.Path)
+			return code
+		}
+	}
+	return exitOK
+}
+
+// runAfterHooks runs every after hook. Failures are reported but do not
+// change the command's exit code.
+func runAfterHooks(chain hookChain, run hookRun, stderr io.Writer) {
+	for _, h := range chain.After {
+		code := runHook(h, "after", run, stderr)
+		if code != exitOK {
+			fmt.Fprintf(stderr, "After hook %q failed with exit code %d\n", h.Run, code)
+		}
+	}
+}
+
+// runWithHooks runs the before hooks, then program, then the after hooks,
+// and returns the exit code of program. In exec mode asd is replaced by the
+// program, so after hooks do not run.
+func runWithHooks(chain hookChain, path string, args []string, settings RunSettings, stderr io.Writer, program func() int) int {
+	if len(chain.Before) == 0 && len(chain.After) == 0 {
+		return program()
+	}
+
+	run := hookRun{Path: path, Args: args}
+	env, err := settings.environment()
+	if err != nil {
+		// The program reports the broken environment itself
+		env = os.Environ()
+	}
+	run.Env = env
+
+	code := runBeforeHooks(chain, run, stderr)
+	if code != exitOK {
+		return code
+	}
+	started := time.Now()
+	run.ExitCode = program()
+	run.Duration = time.Since(started)
+	runAfterHooks(chain, run, stderr)
+	return run.ExitCode
+}
+
+// describe returns one line per hook, for asd explain.
+func (c hookChain) describe() (before []string, after []string) {
+	for _, h := range c.Before {
+		before = append(before, h.Run+" (in "+h.Dir+")")
+	}
+	for _, h := range c.After {
+		after = append(after, h.Run+" (in "+h.Dir+")")
+	}
+	return before, after
+}
@@ -0,0 +1,206 @@
+// languages.go
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Language describes how new-command creates a command in one language and
+// how asd runs and checks it.
+type Language struct {
+	Name string
+	// Runner is the runner kind registered for the command, which decides
+	// how it runs and the extension of its source file.
+	Runner string
+	// Shebang is the first line of scripts, available to templates as
+	// {{.Shebang}}.
+	Shebang string
+	// Template is the scaffold template used without --template.
+	Template string
+	// Manifest is the dependency file created in the category folder when
+	// it does not exist yet, from the ManifestContent template.
+	Manifest        string
+	ManifestContent string
+	// Check is the argv that checks a source without running it, {source}
+	// is replaced with its path. Used by asd doctor --check.
+	Check []string
+}
+
+var languages = map[string]Language{
+	"python": {
+		Name:            "python",
+		Runner:          runnerPython,
+		Shebang:         "#!/usr/bin/env python3",
+		Template:        runnerPython,
+		Manifest:        "requirements.txt",
+		ManifestContent: "# Dependencies of the Python commands in {{.Category}}, install with pip install -r requirements.txt\n",
+		Check:           []string{"python3", "-m", "py_compile", "{source}"},
+	},
+	"node": {
+		Name:     "node",
+		Runner:   runnerNode,
+		Shebang:  "#!/usr/bin/env node",
+		Template: runnerNode,
+		Manifest: "package.json",
+		ManifestContent: `{
+  "name": {{quote .Category}},
+  "private": true,
+  "dependencies": {}
+}
+`,
+		Check: []string{"node", "--check", "{source}"},
+	},
+	"ruby": {
+		Name:            "ruby",
+		Runner:          runnerRuby,
+		Shebang:         "#!/usr/bin/env ruby",
+		Template:        runnerRuby,
+		Manifest:        "Gemfile",
+		ManifestContent: "source \"https://rubygems.org\"\n",
+		Check:           []string{"ruby", "-c", "{source}"},
+	},
+	"bash": {
+		Name:     "bash",
+		Runner:   runnerShell,
+		Shebang:  "#!/bin/bash",
+		Template: runnerShell,
+		Check:    []string{"bash", "-n", "{source}"},
+	},
+	"go": {
+		Name:     "go",
+		Runner:   runnerGo,
+		Template: runnerGo,
+		Check:    []string{"go", "vet", "{source}"},
+	},
+}
+
+var languageName string
+
+var newCommandCmd = &cobra.Command{
+	Use:   "new-command [name] [categoryPath]",
+	Short: "Creates a new command in any supported language under a category",
+	Long: "Creates a new command under a category, with its source scaffolded from the language's\n" +
+		"template and registered in commands.yaml. Languages with a dependency manifest, e.g.\n" +
+		"requirements.txt for python, get one in the category folder unless it already exists.",
+	Example: "  asd new-command report tools --lang python -d \"Prints the weekly report\"",
+	Args:    cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		commandName := args[0]
+		categoryName := args[1]
+
+		language, ok := languages[languageName]
+		if !ok {
+			fail(exitUsage, "Unknown language %q, expected one of: %s", languageName, strings.Join(languageNames(), ", "))
+			return
+		}
+
+		err := registry().Update(func(config *Config) error {
+			category, err := findParentCategory(categoryName, config.Categories)
+			if err != nil {
+				return err
+			}
+			return addNewLanguageCommand(commandName, language, category, categoryPathOf(category, config.Categories))
+		})
+		if err != nil {
+			fail(codeFor(err), "Failed to add new %s command: %s", language.Name, err)
+			return
+		}
+
+		fmt.Printf("Created new %s command: %s in category: %s\n", language.Name, commandName, categoryName)
+	},
+}
+
+func init() {
+	newCommandCmd.Flags().StringVar(&languageName, "lang", "", "Language of the command: "+strings.Join(languageNames(), ", "))
+	newCommandCmd.MarkFlagRequired("lang")
+	newCommandCmd.RegisterFlagCompletionFunc("lang", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return languageNames(), cobra.ShellCompDirectiveNoFileComp
+	})
+}
+
+// languageNames returns the registered languages, sorted.
+func languageNames() []string {
+	var names []string
+	for name := range languages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// languageForRunner returns the language that creates commands of a runner
+// kind.
+func languageForRunner(kind string) (Language, bool) {
+	for _, language := range languages {
+		if language.Runner == kind {
+			return language, true
+		}
+	}
+	return Language{}, false
+}
+
+// addNewLanguageCommand adds a command in language to category and creates
+// its source and, if missing, the language's dependency manifest.
+func addNewLanguageCommand(commandName string, language Language, category *Category, categoryPath string) error {
+	newCommand := Command{
+		Name:     commandName,
+		Runner:   language.Runner,
+		Metadata: commandMetadata,
+	}
+	category.Commands = append(category.Commands, newCommand)
+
+	dir := resolveCategoryPath(category.Path)
+	err := scaffoldCommand(dir, newCommand, categoryPath)
+	if err != nil {
+		return err
+	}
+	return createManifest(dir, language, newTemplateData(dir, newCommand, categoryPath))
+}
+
+// createManifest writes the language's dependency manifest into dir unless
+// the folder already has one, which the new command then shares.
+func createManifest(dir string, language Language, data templateData) error {
+	if language.Manifest == "" {
+		return nil
+	}
+	path := filepath.Join(dir, language.Manifest)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	content, err := renderTemplate(language.Manifest, language.ManifestContent, data)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(content), 0644)
+}
+
+// checkSource runs the language check of the runner on source. It returns
+// nil when the runner's language has no check.
+func checkSource(kind string, source string) error {
+	language, ok := languageForRunner(kind)
+	if !ok || len(language.Check) == 0 {
+		return nil
+	}
+	argv := make([]string, len(language.Check))
+	for i, element := range language.Check {
+		argv[i] = strings.ReplaceAll(element, "{source}", source)
+	}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Dir = filepath.Dir(source)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if message := strings.TrimSpace(string(output)); message != "" {
+			return fmt.Errorf("%s: %s", quoteArgv(argv), message)
+		}
+		return fmt.Errorf("%s: %w", quoteArgv(argv), err)
+	}
+	return nil
+}
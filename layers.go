@@ -0,0 +1,194 @@
+// layers.go
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Layer names, in the order they are merged. Later layers override earlier ones.
+const (
+	layerGlobal  = "global"
+	layerTeam    = "team"
+	layerProject = "project"
+	layerUser    = "user"
+)
+
+type configLayer struct {
+	Name string
+	Path string
+}
+
+// configLayers returns every layer that should be merged into the command tree.
+// The project layer is the file found by findConfigFile, the others are
+// optional and skipped when the file does not exist.
+func configLayers() []configLayer {
+	var layers []configLayer
+
+	globalPath := os.Getenv("ASD_GLOBAL_CONFIG")
+	if globalPath == "" {
+		if runtime.GOOS == "windows" {
+			globalPath, _ = findConfigInDir(filepath.Join(os.Getenv("ProgramData"), "asd"))
+		} else {
+			globalPath, _ = findConfigInDir(filepath.Join("/etc", "asd"))
+		}
+	}
+	layers = append(layers, configLayer{Name: layerGlobal, Path: globalPath})
+
+	if teamPath := os.Getenv("ASD_TEAM_CONFIG"); teamPath != "" {
+		layers = append(layers, configLayer{Name: layerTeam, Path: teamPath})
+	}
+
+	layers = append(layers, configLayer{Name: layerProject, Path: configPath})
+
+	if userDir, err := userConfigDir(); err == nil {
+		userPath, _ := findConfigInDir(userDir)
+		layers = append(layers, configLayer{Name: layerUser, Path: userPath})
+	}
+
+	return layers
+}
+
+// loadLayeredConfig reads every existing layer and merges them into one Config.
+// Category paths are resolved against the layer they were defined in, so the
+// merged tree can be run from anywhere.
+func loadLayeredConfig() (Config, error) {
+	var merged Config
+	var rootHooks hookChain
+	seen := make(map[string]bool)
+
+	for _, layer := range configLayers() {
+		absPath, err := filepath.Abs(layer.Path)
+		if err != nil {
+			return Config{}, err
+		}
+		// The project config may itself be the user-level file
+		if seen[absPath] {
+			continue
+		}
+		seen[absPath] = true
+
+		config, err := newRegistryStore(absPath).Load()
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return Config{}, err
+		}
+
+		annotateCategories(config.Categories, layer.Name, filepath.Dir(absPath))
+		merged.Categories = mergeCategories(merged.Categories, config.Categories)
+		rootHooks = rootHooks.wrap(config.Hooks, filepath.Dir(absPath))
+	}
+
+	// Resolve again on the merged tree, so a category's settings and hooks
+	// reach commands that another layer adds to it
+	resolveCategories(merged.Categories, RunSettings{}, hookChain{})
+	// Root hooks of every layer apply to every command, wherever it is defined
+	applyRootHooks(merged.Categories, rootHooks)
+	return merged, nil
+}
+
+// annotateCategories records the originating layer and resolved directory on
+// every category and command of a freshly loaded layer. It also resolves the
+// run settings and hooks each command inherits from its categories.
+func annotateCategories(categories []Category, layerName string, baseDir string) {
+	recordLayer(categories, layerName, baseDir)
+	resolveCategories(categories, RunSettings{}, hookChain{})
+}
+
+func recordLayer(categories []Category, layerName string, baseDir string) {
+	for i := range categories {
+		category := &categories[i]
+		category.Layer = layerName
+		if filepath.IsAbs(category.Path) {
+			category.Dir = category.Path
+		} else {
+			category.Dir = filepath.Join(baseDir, category.Path)
+		}
+		category.OwnSettings = inheritSettings(RunSettings{}, category.RunSettings, baseDir)
+		category.OwnHooks = hookChain{}.wrap(category.Hooks, baseDir)
+		for j := range category.Commands {
+			command := &category.Commands[j]
+			command.Layer = layerName
+			command.Dir = category.Dir
+			command.BaseDir = baseDir
+		}
+		recordLayer(category.Subcategories, layerName, baseDir)
+	}
+}
+
+// resolveCategories sets the run settings and hooks of every command from
+// those of its categories, outermost first.
+func resolveCategories(categories []Category, inherited RunSettings, inheritedHooks hookChain) {
+	for i := range categories {
+		category := &categories[i]
+		// The paths in OwnSettings are already resolved
+		settings := inheritSettings(inherited, category.OwnSettings, "")
+		hooks := inheritedHooks.nest(category.OwnHooks)
+		for j := range category.Commands {
+			command := &category.Commands[j]
+			command.Resolved = inheritSettings(settings, command.RunSettings, command.BaseDir)
+			command.ResolvedHooks = hooks
+		}
+		resolveCategories(category.Subcategories, settings, hooks)
+	}
+}
+
+// mergeCategories merges overlay into base. Categories with the same name are
+// merged recursively: their commands and subcategories are combined, and on a
+// name clash the entry from overlay replaces the one from base. Settings and
+// hooks of both apply, those of overlay taking precedence.
+func mergeCategories(base []Category, overlay []Category) []Category {
+	for _, category := range overlay {
+		index := -1
+		for i := range base {
+			if base[i].Name == category.Name {
+				index = i
+				break
+			}
+		}
+		if index == -1 {
+			base = append(base, category)
+			continue
+		}
+
+		existing := &base[index]
+		if category.Path != "" {
+			existing.Path = category.Path
+			existing.Dir = category.Dir
+		}
+		existing.Layer = category.Layer
+		existing.OwnSettings = inheritSettings(existing.OwnSettings, category.OwnSettings, "")
+		existing.OwnHooks = existing.OwnHooks.nest(category.OwnHooks)
+		existing.Commands = mergeCommands(existing.Commands, category.Commands)
+		existing.Subcategories = mergeCategories(existing.Subcategories, category.Subcategories)
+	}
+	return base
+}
+
+func mergeCommands(base []Command, overlay []Command) []Command {
+	for _, command := range overlay {
+		replaced := false
+		for i := range base {
+			if base[i].Name == command.Name {
+				base[i] = command
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			base = append(base, command)
+		}
+	}
+	return base
+}
+
+// commandDir returns the directory holding a command's executable.
+func commandDir(category Category, command Command) string {
+	if command.Dir != "" {
+		return command.Dir
+	}
+	return resolveCategoryPath(category.Path)
+}
@@ -0,0 +1,96 @@
+// metadata.go
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Metadata is the help information shared by commands and categories. It is
+// inlined into Command and Category, so the keys sit next to name and path.
+type Metadata struct {
+	Description string   `yaml:"description,omitempty" json:"description,omitempty" toml:"description,omitempty"`
+	Long        string   `yaml:"long,omitempty" json:"long,omitempty" toml:"long,omitempty"`
+	Usage       string   `yaml:"usage,omitempty" json:"usage,omitempty" toml:"usage,omitempty"`
+	Examples    []string `yaml:"examples,omitempty" json:"examples,omitempty" toml:"examples,omitempty"`
+	Aliases     []string `yaml:"aliases,omitempty" json:"aliases,omitempty" toml:"aliases,omitempty"`
+	Hidden      bool     `yaml:"hidden,omitempty" json:"hidden,omitempty" toml:"hidden,omitempty"`
+}
+
+// commandMetadata and categoryMetadata hold the metadata flags of the
+// new-*-command and new-category commands.
+var commandMetadata Metadata
+var categoryMetadata Metadata
+
+func init() {
+	addMetadataFlags(newGoCommandCmd, &commandMetadata)
+	addMetadataFlags(newLinuxCommandCmd, &commandMetadata)
+	addMetadataFlags(newCommandCmd, &commandMetadata)
+	addMetadataFlags(newCategoryCmd, &categoryMetadata)
+}
+
+func addMetadataFlags(cmd *cobra.Command, metadata *Metadata) {
+	cmd.Flags().StringVarP(&metadata.Description, "description", "d", "", "One line description shown in help")
+	cmd.Flags().StringVar(&metadata.Long, "long", "", "Long description shown by --help")
+	cmd.Flags().StringVar(&metadata.Usage, "usage", "", "Arguments shown after the name in the usage line, e.g. \"[env] [version]\"")
+	cmd.Flags().StringArrayVar(&metadata.Examples, "example", nil, "Example invocation, can be repeated")
+	cmd.Flags().StringSliceVar(&metadata.Aliases, "alias", nil, "Alternative name, can be repeated")
+	cmd.Flags().BoolVar(&metadata.Hidden, "hidden", false, "Hide from help and completion")
+}
+
+// applyMetadata copies metadata onto a generated cobra command. defaultShort
+// is used when no description is set.
+func applyMetadata(cmd *cobra.Command, name string, metadata Metadata, defaultShort string) {
+	cmd.Use = name
+	if metadata.Usage != "" {
+		cmd.Use = name + " " + metadata.Usage
+	}
+
+	cmd.Short = defaultShort
+	if metadata.Description != "" {
+		cmd.Short = metadata.Description
+	}
+	cmd.Long = metadata.Long
+
+	var examples []string
+	for _, example := range metadata.Examples {
+		examples = append(examples, "  "+example)
+	}
+	cmd.Example = strings.Join(examples, "\n")
+
+	cmd.Aliases = metadata.Aliases
+	cmd.Hidden = metadata.Hidden
+}
+
+// newCategoryCommand builds the cobra command for a category. Without
+// arguments it shows its help; an unknown command name fails with
+// exitNotFound, so a typo stops scripts.
+func newCategoryCommand(category Category) *cobra.Command {
+	catCmd := &cobra.Command{
+		Args:        cobra.ArbitraryArgs,
+		Annotations: map[string]string{categoryAnnotation: "true"},
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				cmd.Help()
+				return
+			}
+			message := fmt.Sprintf("Error: unknown command %q for %q", args[0], cmd.CommandPath())
+			if suggestions := cmd.SuggestionsFor(args[0]); len(suggestions) > 0 {
+				message += "\n\nDid you mean this?\n\t" + strings.Join(suggestions, "\n\t")
+			}
+			fail(exitNotFound, "%s\nRun '%s --help' for usage.", message, cmd.CommandPath())
+		},
+	}
+	applyMetadata(catCmd, category.Name, category.Metadata, "Commands under "+category.Name)
+	return catCmd
+}
+
+// categoryAnnotation marks the cobra commands of categories, which have a Run
+// of their own to report unknown subcommands.
+const categoryAnnotation = "asd-category"
+
+func isCategoryCommand(cmd *cobra.Command) bool {
+	return cmd.Annotations[categoryAnnotation] != ""
+}
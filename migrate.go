@@ -0,0 +1,152 @@
+// migrate.go
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// migration upgrades a decoded registry document by exactly one version.
+// Documents are format independent: maps are map[string]interface{} and
+// lists are []interface{}, whether the file is YAML, JSON or TOML.
+type migration struct {
+	Description string
+	Migrate     func(doc map[string]interface{}) (map[string]interface{}, error)
+}
+
+// migrations[i] upgrades a file from version i to version i+1. Files written
+// before the version key existed are treated as version 0. Append new entries
+// here whenever Command, Category or Config change shape.
+var migrations = []migration{
+	{
+		Description: "add the version key",
+		Migrate: func(doc map[string]interface{}) (map[string]interface{}, error) {
+			return doc, nil
+		},
+	},
+	{
+		Description: "replace command extension with runner",
+		Migrate: func(doc map[string]interface{}) (map[string]interface{}, error) {
+			migrateExtensionToRunner(doc["categories"])
+			return doc, nil
+		},
+	},
+}
+
+// currentConfigVersion is the version written by this build of asd.
+var currentConfigVersion = len(migrations)
+
+var migrateDryRun bool
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrades commands.yaml to the current schema version",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		store := registry()
+		written := false
+		err := store.Migrate(func(before []byte, after []byte, fromVersion int) bool {
+			if fromVersion == currentConfigVersion {
+				fmt.Printf("%s is already at version %d\n", store.Path(), currentConfigVersion)
+				return false
+			}
+
+			fmt.Printf("Migrating %s from version %d to %d\n", store.Path(), fromVersion, currentConfigVersion)
+			for version := fromVersion; version < currentConfigVersion; version++ {
+				fmt.Printf("  %d -> %d: %s\n", version, version+1, migrations[version].Description)
+			}
+			fmt.Println()
+			fmt.Print(lineDiff(string(before), string(after)))
+
+			written = !migrateDryRun && !dryRun
+			return written
+		})
+		if err != nil {
+			fail(exitConfig, "Failed to migrate %s: %s", store.Path(), err)
+			return
+		}
+		if written {
+			fmt.Printf("\nMigrated %s to version %d\n", store.Path(), currentConfigVersion)
+		}
+	},
+}
+
+func init() {
+	migrateCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false, "Print the changes without rewriting the file")
+}
+
+// migrateDocument upgrades a decoded registry document step by step to the
+// current version. It returns the upgraded document and the version it started at.
+func migrateDocument(doc map[string]interface{}) (map[string]interface{}, int, error) {
+	fromVersion := 0
+	if value, ok := doc["version"]; ok {
+		switch version := value.(type) {
+		case int:
+			fromVersion = version
+		case int64:
+			fromVersion = int(version)
+		case float64:
+			fromVersion = int(version)
+		default:
+			return nil, 0, fmt.Errorf("version must be an integer, got %v", value)
+		}
+	}
+
+	if fromVersion > currentConfigVersion {
+		return nil, fromVersion, fmt.Errorf("config version %d is newer than the supported version %d, please upgrade asd", fromVersion, currentConfigVersion)
+	}
+
+	var err error
+	for version := fromVersion; version < currentConfigVersion; version++ {
+		doc, err = migrations[version].Migrate(doc)
+		if err != nil {
+			return nil, fromVersion, fmt.Errorf("migration %d -> %d failed: %s", version, version+1, err)
+		}
+	}
+	doc["version"] = currentConfigVersion
+	return doc, fromVersion, nil
+}
+
+// lineDiff returns a line-by-line diff of two texts, prefixing removed lines
+// with "-", added lines with "+" and unchanged lines with a space.
+func lineDiff(before string, after string) string {
+	a := strings.Split(strings.TrimSuffix(before, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(after, "\n"), "\n")
+
+	// Longest common subsequence table
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var out strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			out.WriteString("  " + a[i] + "\n")
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			out.WriteString("- " + a[i] + "\n")
+			i++
+		default:
+			out.WriteString("+ " + b[j] + "\n")
+			j++
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,104 @@
+// migrate_test.go
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMigrateExtensionToRunner(t *testing.T) {
+	categories := []interface{}{
+		map[string]interface{}{
+			"name": "tools",
+			"commands": []interface{}{
+				map[string]interface{}{"name": "build", "extension": ".exe"},
+				map[string]interface{}{"name": "clean", "extension": ".sh"},
+				map[string]interface{}{"name": "report", "extension": ".py"},
+				map[string]interface{}{"name": "serve", "extension": ""},
+				map[string]interface{}{"name": "deploy", "extension": ".sh", "runner": runnerExec},
+			},
+			"subcategories": []interface{}{
+				map[string]interface{}{
+					"name": "db",
+					"commands": []interface{}{
+						map[string]interface{}{"name": "backup", "extension": ".sh"},
+					},
+				},
+			},
+		},
+	}
+
+	migrateExtensionToRunner(categories)
+
+	tools := categories[0].(map[string]interface{})
+	want := []interface{}{
+		map[string]interface{}{"name": "build", "runner": runnerGo},
+		map[string]interface{}{"name": "clean", "runner": runnerShell},
+		map[string]interface{}{"name": "report", "runner": runnerExec, "file": "report.py"},
+		map[string]interface{}{"name": "serve", "runner": runnerExec},
+		map[string]interface{}{"name": "deploy", "runner": runnerExec},
+	}
+	if !reflect.DeepEqual(tools["commands"], want) {
+		t.Errorf("got commands %v, want %v", tools["commands"], want)
+	}
+
+	db := tools["subcategories"].([]interface{})[0].(map[string]interface{})
+	backup := db["commands"].([]interface{})[0]
+	wantBackup := map[string]interface{}{"name": "backup", "runner": runnerShell}
+	if !reflect.DeepEqual(backup, wantBackup) {
+		t.Errorf("got subcategory command %v, want %v", backup, wantBackup)
+	}
+}
+
+func TestMigrateDocument(t *testing.T) {
+	doc := map[string]interface{}{
+		"categories": []interface{}{
+			map[string]interface{}{
+				"name":     "tools",
+				"commands": []interface{}{map[string]interface{}{"name": "build", "extension": ".exe"}},
+			},
+		},
+	}
+
+	migrated, fromVersion, err := migrateDocument(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fromVersion != 0 {
+		t.Errorf("got from version %d, want 0", fromVersion)
+	}
+	if migrated["version"] != currentConfigVersion {
+		t.Errorf("got version %v, want %d", migrated["version"], currentConfigVersion)
+	}
+	command := migrated["categories"].([]interface{})[0].(map[string]interface{})["commands"].([]interface{})[0].(map[string]interface{})
+	if command["runner"] != runnerGo {
+		t.Errorf("got runner %v, want %s", command["runner"], runnerGo)
+	}
+}
+
+func TestMigrateDocumentVersions(t *testing.T) {
+	tests := []struct {
+		name        string
+		version     interface{}
+		fromVersion int
+		wantErr     bool
+	}{
+		{"yaml int", currentConfigVersion, currentConfigVersion, false},
+		{"toml int64", int64(1), 1, false},
+		{"json float", float64(1), 1, false},
+		{"newer than supported", currentConfigVersion + 1, currentConfigVersion + 1, true},
+		{"not a number", "two", 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, fromVersion, err := migrateDocument(map[string]interface{}{"version": test.version})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error: %t", err, test.wantErr)
+			}
+			if fromVersion != test.fromVersion {
+				t.Errorf("got from version %d, want %d", fromVersion, test.fromVersion)
+			}
+		})
+	}
+}
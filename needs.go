@@ -0,0 +1,216 @@
+// needs.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// satisfied holds the commands that already ran in this invocation of asd,
+// so a command shared by prerequisites and run-all only runs once, even when
+// it is asked for from several goroutines at the same time.
+var satisfied = struct {
+	sync.Mutex
+	runs map[string]*onceRun
+}{runs: make(map[string]*onceRun)}
+
+// onceRun is the single run of a command and its exit code.
+type onceRun struct {
+	once sync.Once
+	code int
+}
+
+// runOnce calls run for the command at path unless it already ran, and
+// returns its exit code. Callers asking while it runs wait for it to finish.
+func runOnce(path string, run func() int) int {
+	satisfied.Lock()
+	r, ok := satisfied.runs[path]
+	if !ok {
+		r = &onceRun{}
+		satisfied.runs[path] = r
+	}
+	satisfied.Unlock()
+
+	r.once.Do(func() { r.code = run() })
+	return r.code
+}
+
+// markSatisfied records that the command at path succeeded without running
+// it through runOnce, e.g. as a workflow step.
+func markSatisfied(path string) {
+	runOnce(path, func() int { return exitOK })
+}
+
+// planNeeds resolves the prerequisites of the command at path into stages.
+// Every command in a stage only needs commands from earlier stages, so the
+// commands within a stage can run in parallel. The command itself is not
+// part of the plan.
+func planNeeds(path string, command Command) ([][]string, error) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	depth := make(map[string]int)
+	var stack []string
+
+	var visit func(path string, needs []string) (int, error)
+	visit = func(path string, needs []string) (int, error) {
+		switch state[path] {
+		case visiting:
+			cycle := append([]string{}, stack...)
+			for len(cycle) > 0 && cycle[0] != path {
+				cycle = cycle[1:]
+			}
+			return 0, fmt.Errorf("needs form a cycle: %s", strings.Join(append(cycle, path), " -> "))
+		case visited:
+			return depth[path], nil
+		}
+		state[path] = visiting
+		stack = append(stack, path)
+
+		level := 0
+		for _, need := range needs {
+			need = strings.Trim(need, "/")
+			target, ok := registeredCommands[need]
+			if !ok {
+				return 0, fmt.Errorf("%s needs %s, command %w", path, need, errNotFound)
+			}
+			needLevel, err := visit(need, target.Command.Needs)
+			if err != nil {
+				return 0, err
+			}
+			if needLevel+1 > level {
+				level = needLevel + 1
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[path] = visited
+		depth[path] = level
+		return level, nil
+	}
+
+	level, err := visit(path, command.Needs)
+	if err != nil {
+		return nil, err
+	}
+	stages := make([][]string, level)
+	for need, needLevel := range depth {
+		if need != path {
+			stages[needLevel] = append(stages[needLevel], need)
+		}
+	}
+	for _, stage := range stages {
+		sort.Strings(stage)
+	}
+	return stages, nil
+}
+
+// runNeeds runs the prerequisites of the command at path, stage by stage,
+// skipping those that already ran. Their output goes to stdout and stderr, or
+// to asd's own when they are nil. Prerequisites run detached when the command
+// does, e.g. under run-all. It returns the exit code of the first
+// prerequisite that failed, or exitOK.
+func runNeeds(path string, command Command, stdout io.Writer, stderr io.Writer) int {
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	stages, err := planNeeds(path, command)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err)
+		return codeFor(err)
+	}
+
+	for _, stage := range stages {
+		// Commands running side by side can't share stdin
+		detached := command.Resolved.Detached || len(stage) > 1
+		codes := make([]int, len(stage))
+		var wg sync.WaitGroup
+		for i, need := range stage {
+			wg.Add(1)
+			go func(i int, need string) {
+				defer wg.Done()
+				codes[i] = runOnce(need, func() int {
+					return runNeed(need, detached, stdout, stderr)
+				})
+			}(i, need)
+		}
+		wg.Wait()
+
+		// Let the whole stage finish before giving up, so nothing is left running
+		for _, code := range codes {
+			if code != exitOK {
+				return code
+			}
+		}
+	}
+	return exitOK
+}
+
+// runNeed runs a single prerequisite with its default arguments.
+func runNeed(path string, detached bool, stdout io.Writer, stderr io.Writer) int {
+	target := registeredCommands[path]
+	command := target.Command
+	command.Resolved.Detached = detached
+	// A prerequisite must never replace asd, the command still has to run
+	command.Resolved.Exec = nil
+
+	label := "[needs] " + path
+	fmt.Fprintf(stderr, "%s: running\n", label)
+	started := time.Now()
+	code := runRegisteredCommand(path, command, target.Dir, command.Resolved.DefaultArgs, stdout, stderr)
+	elapsed := time.Since(started).Round(time.Millisecond)
+
+	if code != exitOK {
+		fmt.Fprintf(stderr, "%s: failed with exit code %d (%s)\n", label, code, elapsed)
+		return code
+	}
+	fmt.Fprintf(stderr, "%s: ok (%s)\n", label, elapsed)
+	return exitOK
+}
+
+var graphCmd = &cobra.Command{
+	Use:   "graph [commandPath]",
+	Short: "Shows the order in which a command and its prerequisites run",
+	Long: "Shows the order in which a command and its prerequisites run.\n" +
+		"The command can be given as a path, e.g. ops/deploy, or as words, e.g. ops deploy.\n" +
+		"Commands with the same number run in parallel.",
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path := strings.Trim(strings.Join(args, "/"), "/")
+		target, ok := registeredCommands[path]
+		if !ok {
+			fail(exitNotFound, "Error: command %s %s", path, errNotFound)
+			return
+		}
+
+		stages, err := planNeeds(path, target.Command)
+		if err != nil {
+			fail(codeFor(err), "Error: %s", err)
+			return
+		}
+
+		fmt.Printf("Plan for %s:\n", path)
+		for i, stage := range stages {
+			for _, need := range stage {
+				fmt.Printf("  %d. %s%s\n", i+1, need, describeNeeds(registeredCommands[need].Command))
+			}
+		}
+		fmt.Printf("  %d. %s%s\n", len(stages)+1, path, describeNeeds(target.Command))
+	},
+}
+
+func describeNeeds(command Command) string {
+	if len(command.Needs) == 0 {
+		return ""
+	}
+	return " (needs " + strings.Join(command.Needs, ", ") + ")"
+}
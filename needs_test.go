@@ -0,0 +1,96 @@
+// needs_test.go
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// useCommands replaces the registered commands with commands needing each
+// other as given, for the duration of the test.
+func useCommands(t *testing.T, needs map[string][]string) {
+	t.Helper()
+	previous := registeredCommands
+	registeredCommands = make(map[string]registeredCommand)
+	for path, paths := range needs {
+		registeredCommands[path] = registeredCommand{Command: Command{Name: path, Needs: paths}}
+	}
+	t.Cleanup(func() { registeredCommands = previous })
+}
+
+func TestPlanNeedsStages(t *testing.T) {
+	useCommands(t, map[string][]string{
+		"ops/deploy":   {"ops/build", "/ops/test/"},
+		"ops/build":    {"ops/generate"},
+		"ops/test":     {"ops/generate", "ops/lint"},
+		"ops/generate": nil,
+		"ops/lint":     nil,
+	})
+
+	stages, err := planNeeds("ops/deploy", registeredCommands["ops/deploy"].Command)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"ops/generate", "ops/lint"},
+		{"ops/build", "ops/test"},
+	}
+	if !reflect.DeepEqual(stages, want) {
+		t.Errorf("got stages %v, want %v", stages, want)
+	}
+}
+
+func TestPlanNeedsWithoutNeeds(t *testing.T) {
+	useCommands(t, map[string][]string{"ops/lint": nil})
+
+	stages, err := planNeeds("ops/lint", registeredCommands["ops/lint"].Command)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stages) != 0 {
+		t.Errorf("got stages %v, want none", stages)
+	}
+}
+
+func TestPlanNeedsCycle(t *testing.T) {
+	useCommands(t, map[string][]string{
+		"ops/deploy": {"ops/build"},
+		"ops/build":  {"ops/test"},
+		"ops/test":   {"ops/build"},
+	})
+
+	_, err := planNeeds("ops/deploy", registeredCommands["ops/deploy"].Command)
+	if err == nil {
+		t.Fatal("planned a cycle")
+	}
+	if !strings.Contains(err.Error(), "ops/build -> ops/test -> ops/build") {
+		t.Errorf("got %q, want the cycle spelled out", err)
+	}
+}
+
+func TestPlanNeedsUnknownCommand(t *testing.T) {
+	useCommands(t, map[string][]string{"ops/deploy": {"ops/nosuch"}})
+
+	_, err := planNeeds("ops/deploy", registeredCommands["ops/deploy"].Command)
+	if !errors.Is(err, errNotFound) {
+		t.Errorf("got %v, want %v", err, errNotFound)
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+	runs := 0
+	for i := 0; i < 3; i++ {
+		code := runOnce("test/run-once", func() int {
+			runs++
+			return exitFailure
+		})
+		if code != exitFailure {
+			t.Errorf("got exit code %d, want %d", code, exitFailure)
+		}
+	}
+	if runs != 1 {
+		t.Errorf("ran %d times, want once", runs)
+	}
+}
@@ -0,0 +1,42 @@
+// passthrough.go
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// Values for the flags key of a command.
+const (
+	// flagsPassthrough hands every argument to the program untouched. This
+	// is the default.
+	flagsPassthrough = "passthrough"
+	// flagsManaged lets cobra parse flags, so unknown flags are rejected by
+	// asd and arguments after -- are passed on.
+	flagsManaged = "managed"
+)
+
+// configureFlagParsing sets up how a generated cobra command treats its
+// arguments. In passthrough mode -h and --help as the first argument still
+// show asd's help, unless the command sets pass_help.
+func configureFlagParsing(cmd *cobra.Command, command Command) error {
+	switch command.Flags {
+	case "", flagsPassthrough:
+		cmd.DisableFlagParsing = true
+	case flagsManaged:
+		cmd.DisableFlagParsing = false
+	default:
+		return fmt.Errorf("unknown flags mode %q for command %s, expected %s or %s", command.Flags, command.Name, flagsPassthrough, flagsManaged)
+	}
+	return nil
+}
+
+// wantsAsdHelp reports whether a passthrough command should show asd's help
+// instead of running.
+func wantsAsdHelp(cmd *cobra.Command, command Command, args []string) bool {
+	if !cmd.DisableFlagParsing || command.PassHelp || len(args) == 0 {
+		return false
+	}
+	return args[0] == "-h" || args[0] == "--help"
+}
@@ -0,0 +1,125 @@
+// process.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// defaultKillGrace is how long a timed out command gets to exit after the
+// terminate signal before it is killed.
+const defaultKillGrace = 5 * time.Second
+
+// superviseProcess starts cmd in its own process group, forwards signals
+// received by asd to it and enforces the command's timeout. It returns the
+// exit code asd should exit with.
+func superviseProcess(cmd *exec.Cmd, settings RunSettings) int {
+	timeout, grace, err := settings.timeouts()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid timeout for %s: %s\n", cmd.Path, err)
+		return exitConfig
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+
+	var cleanup func()
+	if isOn(settings.TTY) {
+		cleanup, err = startWithPTY(cmd, settings.Detached)
+	} else {
+		cleanup, err = startProcess(cmd, settings.Detached)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to execute program %s: %s\n", cmd.Path, err.Error())
+		return childExitCode(err)
+	}
+	defer cleanup()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var timeoutC, killC <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutC = timer.C
+	}
+
+	timedOut := false
+	for {
+		select {
+		case err := <-done:
+			if timedOut {
+				return exitTimeout
+			}
+			return childExitCode(err)
+		case sig := <-signals:
+			signalProcessGroup(cmd, sig)
+		case <-timeoutC:
+			fmt.Fprintf(os.Stderr, "%s timed out after %s, stopping it\n", cmd.Path, timeout)
+			timedOut = true
+			timeoutC = nil
+			terminateProcessGroup(cmd)
+			killC = time.After(grace)
+		case <-killC:
+			fmt.Fprintf(os.Stderr, "%s did not stop within %s, killing it\n", cmd.Path, grace)
+			killC = nil
+			killProcessGroup(cmd)
+		}
+	}
+}
+
+// startProcess starts cmd with asd's own stdin. Output goes to cmd.Stdout
+// and cmd.Stderr if set, otherwise to asd's own. Detached commands get no
+// stdin.
+func startProcess(cmd *exec.Cmd, detached bool) (func(), error) {
+	if !detached {
+		cmd.Stdin = os.Stdin
+	}
+	if cmd.Stdout == nil {
+		cmd.Stdout = os.Stdout
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+
+	foreground := !detached && term.IsTerminal(int(os.Stdin.Fd()))
+	prepareProcess(cmd, foreground)
+	err := cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	if foreground {
+		return restoreForeground, nil
+	}
+	return func() {}, nil
+}
+
+// timeouts parses the timeout and kill_grace settings. A zero timeout means
+// the command may run forever.
+func (s RunSettings) timeouts() (time.Duration, time.Duration, error) {
+	var timeout time.Duration
+	grace := defaultKillGrace
+	var err error
+	if s.Timeout != "" {
+		timeout, err = time.ParseDuration(s.Timeout)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if s.KillGrace != "" {
+		grace, err = time.ParseDuration(s.KillGrace)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return timeout, grace, nil
+}
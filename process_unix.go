@@ -0,0 +1,73 @@
+//go:build !windows
+
+// process_unix.go
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// forwardedSignals are relayed from asd to the child's process group.
+var forwardedSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP}
+
+// hookShell returns the argv that runs a hook through the shell.
+func hookShell(hook string) []string {
+	return []string{"/bin/sh", "-c", hook}
+}
+
+// prepareProcess starts the child in its own process group, so signals can
+// reach every process it spawns. When stdin is a terminal the group is made
+// the terminal's foreground group, otherwise reading from it would stop the
+// child with SIGTTIN.
+func prepareProcess(cmd *exec.Cmd, foreground bool) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if foreground {
+		cmd.SysProcAttr.Foreground = true
+		// Ctty is a descriptor in the child, where stdin is the terminal
+		cmd.SysProcAttr.Ctty = 0
+	}
+}
+
+// restoreForeground makes asd's process group the terminal's foreground
+// group again once a foreground child has exited.
+func restoreForeground() {
+	// asd is a background group at this point, so the ioctl raises SIGTTOU
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Reset(syscall.SIGTTOU)
+	unix.IoctlSetPointerInt(int(os.Stdin.Fd()), unix.TIOCSPGRP, unix.Getpgrp())
+}
+
+// signalProcessGroup sends sig to the child and all of its descendants.
+func signalProcessGroup(cmd *exec.Cmd, sig os.Signal) error {
+	return syscall.Kill(-cmd.Process.Pid, sig.(syscall.Signal))
+}
+
+// terminateProcessGroup asks the child's process group to stop.
+func terminateProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+}
+
+// killProcessGroup forcibly stops the child's process group.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// execProgram replaces the asd process with argv. It only returns on error.
+func execProgram(argv []string, env []string, dir string) error {
+	program, err := exec.LookPath(argv[0])
+	if err != nil {
+		return err
+	}
+	if dir != "" {
+		err = os.Chdir(dir)
+		if err != nil {
+			return err
+		}
+	}
+	return syscall.Exec(program, argv, env)
+}
@@ -0,0 +1,49 @@
+//go:build windows
+
+// process_windows.go
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// forwardedSignals are relayed from asd to the child.
+var forwardedSignals = []os.Signal{os.Interrupt}
+
+// hookShell returns the argv that runs a hook through the shell.
+func hookShell(hook string) []string {
+	return []string{"cmd", "/C", hook}
+}
+
+// prepareProcess starts the child in its own process group. Windows has no
+// foreground process groups, so foreground is ignored.
+func prepareProcess(cmd *exec.Cmd, foreground bool) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// restoreForeground is a no-op on Windows.
+func restoreForeground() {}
+
+// signalProcessGroup relays sig to the child. Windows cannot deliver
+// arbitrary signals, so anything but an interrupt kills the child.
+func signalProcessGroup(cmd *exec.Cmd, sig os.Signal) error {
+	return cmd.Process.Kill()
+}
+
+// terminateProcessGroup asks the child to stop.
+func terminateProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// killProcessGroup forcibly stops the child.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// execProgram is not supported on Windows, which cannot replace a process.
+func execProgram(argv []string, env []string, dir string) error {
+	return errors.New("exec mode is not supported on windows")
+}
@@ -0,0 +1,122 @@
+// rebuild.go
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// noRebuild is set by the global --no-rebuild flag. Go commands then run
+// whatever binary exists, even if it is out of date.
+var noRebuild bool
+
+// goModuleFiles are the files next to a command's go.mod that affect its build.
+var goModuleFiles = []string{"go.mod", "go.sum"}
+
+// rebuildMu serializes rebuilds, commands run in parallel may share a binary.
+var rebuildMu sync.Mutex
+
+// goRunSources returns the absolute paths of the sources of Go commands in
+// run mode, which asd compile leaves alone.
+func goRunSources() map[string]bool {
+	sources := make(map[string]bool)
+	for _, registered := range registeredCommands {
+		runner, err := runnerFor(registered.Command)
+		if err != nil || runner.Kind != runnerGo || runner.Builds(registered.Command) {
+			continue
+		}
+		if source, err := filepath.Abs(runner.Source(registered.Dir, registered.Command)); err == nil {
+			sources[source] = true
+		}
+	}
+	return sources
+}
+
+// goBuildInputs returns the files a Go command's binary is built from: the
+// source and the go.mod and go.sum of the module it belongs to, if any.
+func goBuildInputs(source string) []string {
+	inputs := []string{source}
+	if module := findGoModule(filepath.Dir(source)); module != "" {
+		for _, name := range goModuleFiles {
+			inputs = append(inputs, filepath.Join(module, name))
+		}
+	}
+	return inputs
+}
+
+// goBinaryStale reports why the binary built from source is out of date, or
+// "" when it is current. A missing source is left to the caller.
+func goBinaryStale(source string) string {
+	artifactInfo, err := os.Stat(goArtifactPath(source))
+	if err != nil {
+		return "binary has not been compiled"
+	}
+	for _, input := range goBuildInputs(source) {
+		info, err := os.Stat(input)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(artifactInfo.ModTime()) {
+			return filepath.Base(input) + " changed since the binary was built"
+		}
+	}
+	return ""
+}
+
+// goRunBinaryPath returns where the binary of a Go command in run mode is
+// kept: in asd's cache folder, one folder per source, so nothing is left next
+// to the source.
+func goRunBinaryPath(source string) string {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		cache = os.TempDir()
+	}
+	if absolute, err := filepath.Abs(source); err == nil {
+		source = absolute
+	}
+	sum := sha256.Sum256([]byte(source))
+	return filepath.Join(cache, "asd", "run", hex.EncodeToString(sum[:8]), goArtifactPath(filepath.Base(source)))
+}
+
+// compileGoRun compiles a Go command in run mode before it runs. go run
+// itself would resolve imports from the directory it runs in, so asd builds
+// in the source's folder instead and runs the binary from the workdir.
+func compileGoRun(source string) error {
+	rebuildMu.Lock()
+	defer rebuildMu.Unlock()
+
+	binary := goRunBinaryPath(source)
+	err := os.MkdirAll(filepath.Dir(binary), 0755)
+	if err != nil {
+		return err
+	}
+	return buildGoBinaryTo(source, binary)
+}
+
+// rebuildEnabled reports whether a Go command is rebuilt before it runs.
+func rebuildEnabled(settings RunSettings) bool {
+	return !noRebuild && !isOn(settings.NoRebuild)
+}
+
+// ensureGoBinary rebuilds the binary of a Go command when its source or
+// module files changed. It never leaves an outdated binary to run: when the
+// build fails the error is returned.
+func ensureGoBinary(source string) error {
+	rebuildMu.Lock()
+	defer rebuildMu.Unlock()
+
+	if _, err := os.Stat(source); err != nil {
+		return err
+	}
+	reason := goBinaryStale(source)
+	if reason == "" {
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Rebuilding %s: %s\n", filepath.Base(source), reason)
+	return buildGoBinary(source)
+}
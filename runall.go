@@ -0,0 +1,272 @@
+// runall.go
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Values for asd run-all --output.
+const (
+	// outputPrefix streams output as it comes, every line prefixed with the
+	// command's path.
+	outputPrefix = "prefix"
+	// outputGrouped buffers the output of each command and prints it in one
+	// block once the command has finished.
+	outputGrouped = "grouped"
+)
+
+var runAllRecursive bool
+var runAllParallel int
+var runAllKeepGoing bool
+var runAllOutput string
+
+// runAllResult is one row of the run-all summary.
+type runAllResult struct {
+	Path     string
+	ExitCode int
+	Duration time.Duration
+	Skipped  bool
+}
+
+var runAllCmd = &cobra.Command{
+	Use:   "run-all [categoryPath]",
+	Short: "Runs every command in a category",
+	Long: "Runs every command in a category, optionally including its subcategories.\n" +
+		"The category can be given as a path, e.g. ops/checks, or as words, e.g. ops checks.\n" +
+		"By default the first failure stops commands that have not started yet. asd exits\n" +
+		"with the exit code of the first command that failed.",
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if runAllParallel < 1 {
+			fail(exitUsage, "Error: --parallel must be at least 1")
+			return
+		}
+		if runAllOutput != outputPrefix && runAllOutput != outputGrouped {
+			fail(exitUsage, "Error: unknown output mode %q, expected %s or %s", runAllOutput, outputPrefix, outputGrouped)
+			return
+		}
+
+		categoryPath := strings.Trim(strings.Join(args, "/"), "/")
+		found, _, err := cmd.Root().Find(strings.Split(categoryPath, "/"))
+		if err != nil || commandPathOf(found) != categoryPath || !isCategoryCommand(found) {
+			fail(exitNotFound, "Error: category %s %s", categoryPath, errNotFound)
+			return
+		}
+
+		paths := commandsInCategory(categoryPath, runAllRecursive)
+		if len(paths) == 0 {
+			fmt.Printf("No commands in %s\n", categoryPath)
+			return
+		}
+
+		if dryRun {
+			for _, path := range paths {
+				target := registeredCommands[path]
+				err := explainCommand(os.Stdout, path, target.Command, target.Dir, target.Command.Resolved.DefaultArgs)
+				if err != nil {
+					fail(codeFor(err), "Error: %s", err)
+				}
+			}
+			return
+		}
+
+		results := runAll(paths)
+		printRunAllSummary(results)
+		for _, result := range results {
+			if !result.Skipped && result.ExitCode != exitOK {
+				exitCode = result.ExitCode
+				return
+			}
+		}
+	},
+}
+
+func init() {
+	runAllCmd.Flags().BoolVarP(&runAllRecursive, "recursive", "r", false, "Also run the commands in subcategories")
+	runAllCmd.Flags().IntVarP(&runAllParallel, "parallel", "p", runtime.NumCPU(), "Number of commands to run at the same time")
+	runAllCmd.Flags().BoolVarP(&runAllKeepGoing, "keep-going", "k", false, "Keep starting commands after one has failed")
+	runAllCmd.Flags().StringVar(&runAllOutput, "output", outputPrefix, "How to show output: prefix streams lines prefixed with the command, grouped prints each command's output once it finishes")
+}
+
+// commandsInCategory returns the paths of the commands in a category, sorted.
+func commandsInCategory(categoryPath string, recursive bool) []string {
+	var paths []string
+	for path := range registeredCommands {
+		rest := strings.TrimPrefix(path, categoryPath+"/")
+		if rest == path {
+			continue
+		}
+		if recursive || !strings.Contains(rest, "/") {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// runAll runs the commands at paths, at most --parallel at a time, and
+// returns a result for each in the same order.
+func runAll(paths []string) []runAllResult {
+	results := make([]runAllResult, len(paths))
+	detached := runAllParallel > 1
+	var outputMu sync.Mutex
+	var stopped atomic.Bool
+
+	slots := make(chan struct{}, runAllParallel)
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		slots <- struct{}{}
+		if stopped.Load() {
+			results[i] = runAllResult{Path: path, Skipped: true}
+			<-slots
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[i] = runOneOfAll(path, detached, &outputMu)
+			if results[i].ExitCode != exitOK && !runAllKeepGoing {
+				stopped.Store(true)
+			}
+		}(i, path)
+	}
+	wg.Wait()
+	return results
+}
+
+// runOneOfAll runs a single command for run-all with its default arguments.
+// outputMu keeps the output of commands running side by side apart.
+func runOneOfAll(path string, detached bool, outputMu *sync.Mutex) runAllResult {
+	target := registeredCommands[path]
+	command := target.Command
+	command.Resolved.Detached = detached
+	command.Resolved.Exec = nil
+
+	// Members are marked as run, so commands needing them don't run them again
+	run := func(stdout io.Writer, stderr io.Writer) int {
+		return runOnce(path, func() int {
+			code := runNeeds(path, command, stdout, stderr)
+			if code != exitOK {
+				return code
+			}
+			return runRegisteredCommand(path, command, target.Dir, command.Resolved.DefaultArgs, stdout, stderr)
+		})
+	}
+
+	started := time.Now()
+	var code int
+	if runAllOutput == outputGrouped {
+		var buffer syncBuffer
+		code = run(&buffer, &buffer)
+		elapsed := time.Since(started).Round(time.Millisecond)
+
+		outputMu.Lock()
+		fmt.Printf("==> %s (exit %d, %s)\n", path, code, elapsed)
+		output := buffer.Bytes()
+		os.Stdout.Write(output)
+		if len(output) > 0 && output[len(output)-1] != '\n' {
+			fmt.Println()
+		}
+		outputMu.Unlock()
+	} else {
+		prefix := "[" + path + "] "
+		stdout := &prefixWriter{mu: outputMu, out: os.Stdout, prefix: prefix}
+		stderr := &prefixWriter{mu: outputMu, out: os.Stderr, prefix: prefix}
+		code = run(stdout, stderr)
+		stdout.Flush()
+		stderr.Flush()
+	}
+
+	return runAllResult{Path: path, ExitCode: code, Duration: time.Since(started)}
+}
+
+func printRunAllSummary(results []runAllResult) {
+	fmt.Println()
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "COMMAND\tSTATUS\tEXIT\tDURATION")
+	for _, result := range results {
+		switch {
+		case result.Skipped:
+			fmt.Fprintf(writer, "%s\tskipped\t-\t-\n", result.Path)
+		case result.ExitCode == exitOK:
+			fmt.Fprintf(writer, "%s\tok\t%d\t%s\n", result.Path, result.ExitCode, result.Duration.Round(time.Millisecond))
+		default:
+			fmt.Fprintf(writer, "%s\tfailed\t%d\t%s\n", result.Path, result.ExitCode, result.Duration.Round(time.Millisecond))
+		}
+	}
+	writer.Flush()
+}
+
+// prefixWriter writes complete lines to out, each starting with prefix. mu
+// is shared between the writers of all commands so lines never interleave,
+// and guards pending too, since the prerequisites of a command write to its
+// writers at the same time.
+type prefixWriter struct {
+	mu      *sync.Mutex
+	out     io.Writer
+	prefix  string
+	pending []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending = append(w.pending, p...)
+	for {
+		end := bytes.IndexByte(w.pending, '\n')
+		if end < 0 {
+			return len(p), nil
+		}
+		w.writeLine(w.pending[:end+1])
+		w.pending = w.pending[end+1:]
+	}
+}
+
+// Flush writes a last line that did not end in a newline.
+func (w *prefixWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.pending) > 0 {
+		w.writeLine(append(w.pending, '\n'))
+		w.pending = nil
+	}
+}
+
+// writeLine is called with mu held.
+func (w *prefixWriter) writeLine(line []byte) {
+	io.WriteString(w.out, w.prefix)
+	w.out.Write(line)
+}
+
+// syncBuffer is a bytes.Buffer that several writers, e.g. stdout and stderr
+// or commands running side by side, can write to at once.
+type syncBuffer struct {
+	mu     sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.Bytes()
+}
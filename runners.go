@@ -0,0 +1,205 @@
+// runners.go
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Runner kinds accepted in the runner key of a command.
+const (
+	runnerGo          = "go"
+	runnerShell       = "shell"
+	runnerPython      = "python"
+	runnerNode        = "node"
+	runnerRuby        = "ruby"
+	runnerExec        = "exec"
+	runnerInterpreter = "interpreter"
+)
+
+// Values for the mode key, which selects how Go commands run.
+const (
+	// goModeBuild compiles the command to a binary next to its source and
+	// runs the binary. This is the default.
+	goModeBuild = "build"
+	// goModeRun compiles the source on every run, as go run does, keeping
+	// the binary in asd's cache folder instead of next to the source.
+	goModeRun = "run"
+)
+
+// Runner knows where a command's files live and how to invoke it.
+type Runner struct {
+	Kind string
+	// SourceExt is appended to the command name to find the source file
+	// when the command does not set file.
+	SourceExt string
+	// DefaultInterpreter is used when the command does not set interpreter.
+	DefaultInterpreter string
+	// Compiled runners execute an artifact built from the source.
+	Compiled bool
+	// Executable runners are run directly and need the executable bit.
+	Executable bool
+}
+
+var runners = map[string]Runner{
+	runnerGo:          {Kind: runnerGo, SourceExt: ".go", Compiled: true},
+	runnerShell:       {Kind: runnerShell, SourceExt: ".sh", Executable: true},
+	runnerPython:      {Kind: runnerPython, SourceExt: ".py", DefaultInterpreter: "python3"},
+	runnerNode:        {Kind: runnerNode, SourceExt: ".js", DefaultInterpreter: "node"},
+	runnerRuby:        {Kind: runnerRuby, SourceExt: ".rb", DefaultInterpreter: "ruby"},
+	runnerExec:        {Kind: runnerExec, Executable: true},
+	runnerInterpreter: {Kind: runnerInterpreter},
+}
+
+// runnerKinds returns the registered runner kinds, sorted.
+func runnerKinds() []string {
+	var kinds []string
+	for kind := range runners {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// runnerFor returns the runner for a command. Commands without a runner are
+// run as raw executables.
+func runnerFor(command Command) (Runner, error) {
+	kind := command.Runner
+	if kind == "" {
+		kind = runnerExec
+	}
+	runner, ok := runners[kind]
+	if !ok {
+		return Runner{}, fmt.Errorf("unknown runner %q for command %s, expected one of: %s", kind, command.Name, strings.Join(runnerKinds(), ", "))
+	}
+	switch command.Resolved.Mode {
+	case "", goModeBuild, goModeRun:
+	default:
+		return Runner{}, fmt.Errorf("unknown mode %q for command %s, expected %s or %s", command.Resolved.Mode, command.Name, goModeBuild, goModeRun)
+	}
+	return runner, nil
+}
+
+// Builds reports whether the command runs a binary that asd builds from its
+// source. Go commands in run mode are compiled before every run instead. The mode
+// can be set on a category, so it is ignored by every other runner.
+func (r Runner) Builds(command Command) bool {
+	return r.Compiled && command.Resolved.Mode != goModeRun
+}
+
+// Source returns the file the user edits for a command in dir.
+func (r Runner) Source(dir string, command Command) string {
+	if command.File != "" {
+		return resolveAgainst(dir, command.File)
+	}
+	return filepath.Join(dir, command.Name+r.SourceExt)
+}
+
+// Artifact returns the file that is actually executed. For runners that
+// build a binary this is the binary next to the source, for Go commands in
+// run mode the binary in asd's cache, otherwise it is the source itself.
+func (r Runner) Artifact(dir string, command Command) string {
+	source := r.Source(dir, command)
+	switch {
+	case r.Builds(command):
+		return goArtifactPath(source)
+	case r.Compiled:
+		return goRunBinaryPath(source)
+	default:
+		return source
+	}
+}
+
+// Invocation returns the argv that runs command with args.
+func (r Runner) Invocation(dir string, command Command, args []string) ([]string, error) {
+	switch {
+	case r.DefaultInterpreter != "":
+		interpreter := command.Interpreter
+		if interpreter == "" {
+			interpreter = r.DefaultInterpreter
+		}
+		return append([]string{interpreter, r.Source(dir, command)}, args...), nil
+	case r.Kind == runnerInterpreter:
+		if len(command.Argv) == 0 {
+			return nil, fmt.Errorf("command %s uses the interpreter runner but has no argv template", command.Name)
+		}
+		return expandArgvTemplate(command.Argv, dir, command, r.Source(dir, command), args), nil
+	default:
+		return append([]string{r.Artifact(dir, command)}, args...), nil
+	}
+}
+
+// expandArgvTemplate fills in an interpreter argv template. {source}, {dir}
+// and {name} are replaced inside any element, an element that is exactly
+// {args} expands to all arguments. Arguments are appended when the template
+// does not mention {args}.
+func expandArgvTemplate(template []string, dir string, command Command, source string, args []string) []string {
+	replacer := strings.NewReplacer("{source}", source, "{dir}", dir, "{name}", command.Name)
+
+	var argv []string
+	usedArgs := false
+	for _, element := range template {
+		if element == "{args}" {
+			argv = append(argv, args...)
+			usedArgs = true
+			continue
+		}
+		argv = append(argv, replacer.Replace(element))
+	}
+	if !usedArgs {
+		argv = append(argv, args...)
+	}
+	return argv
+}
+
+// goArtifactPath returns the binary built from a .go file. Only Windows
+// binaries get the .exe suffix.
+func goArtifactPath(source string) string {
+	binary := strings.TrimSuffix(source, ".go")
+	if runtime.GOOS == "windows" {
+		binary += ".exe"
+	}
+	return binary
+}
+
+// migrateExtensionToRunner converts the extension key of every command in a
+// registry document into a runner, keeping other extensions as a file name.
+func migrateExtensionToRunner(categories interface{}) {
+	list, _ := categories.([]interface{})
+	for _, item := range list {
+		category, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		commands, _ := category["commands"].([]interface{})
+		for _, commandItem := range commands {
+			command, ok := commandItem.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if _, hasRunner := command["runner"]; hasRunner {
+				delete(command, "extension")
+				continue
+			}
+
+			extension, _ := command["extension"].(string)
+			delete(command, "extension")
+			switch extension {
+			case ".exe":
+				command["runner"] = runnerGo
+			case ".sh":
+				command["runner"] = runnerShell
+			default:
+				command["runner"] = runnerExec
+				if extension != "" {
+					name, _ := command["name"].(string)
+					command["file"] = name + extension
+				}
+			}
+		}
+		migrateExtensionToRunner(category["subcategories"])
+	}
+}
@@ -0,0 +1,202 @@
+// settings.go
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// RunSettings controls the environment a command runs in. Categories carry
+// defaults that their commands and subcategories inherit.
+type RunSettings struct {
+	Env         map[string]string `yaml:"env,omitempty" json:"env,omitempty" toml:"env,omitempty"`
+	EnvFile     string            `yaml:"env_file,omitempty" json:"env_file,omitempty" toml:"env_file,omitempty"`
+	Workdir     string            `yaml:"workdir,omitempty" json:"workdir,omitempty" toml:"workdir,omitempty"`
+	DefaultArgs []string          `yaml:"default_args,omitempty" json:"default_args,omitempty" toml:"default_args,omitempty"`
+	// Timeout and KillGrace are Go durations such as "30s" or "5m".
+	Timeout   string `yaml:"timeout,omitempty" json:"timeout,omitempty" toml:"timeout,omitempty"`
+	KillGrace string `yaml:"kill_grace,omitempty" json:"kill_grace,omitempty" toml:"kill_grace,omitempty"`
+	// Exec replaces the asd process with the command instead of running it
+	// as a child. Timeouts do not apply. The switches are pointers so a
+	// command can turn off what its category turns on.
+	Exec *bool `yaml:"exec,omitempty" json:"exec,omitempty" toml:"exec,omitempty"`
+	// TTY runs the command under a pseudo-terminal, for interactive tools.
+	TTY *bool `yaml:"tty,omitempty" json:"tty,omitempty" toml:"tty,omitempty"`
+	// NoRebuild runs Go commands without checking whether their binary is
+	// out of date, for commands on hot paths.
+	NoRebuild *bool `yaml:"no_rebuild,omitempty" json:"no_rebuild,omitempty" toml:"no_rebuild,omitempty"`
+	// Mode selects how Go commands run: build (the default) or run.
+	Mode string `yaml:"mode,omitempty" json:"mode,omitempty" toml:"mode,omitempty"`
+
+	// Detached runs the command without stdin, so several commands can run
+	// side by side. Set by asd, never written to commands.yaml.
+	Detached bool `yaml:"-" json:"-" toml:"-"`
+}
+
+// inheritSettings layers child on top of parent. Env entries are merged key
+// by key, every other field is replaced when the child sets it, including
+// switches set to false. Relative paths are resolved against baseDir, the
+// directory of the config file.
+func inheritSettings(parent RunSettings, child RunSettings, baseDir string) RunSettings {
+	result := RunSettings{
+		EnvFile:     parent.EnvFile,
+		Workdir:     parent.Workdir,
+		DefaultArgs: parent.DefaultArgs,
+		Timeout:     parent.Timeout,
+		KillGrace:   parent.KillGrace,
+		Exec:        parent.Exec,
+		TTY:         parent.TTY,
+		NoRebuild:   parent.NoRebuild,
+		Mode:        parent.Mode,
+	}
+
+	if len(parent.Env) > 0 || len(child.Env) > 0 {
+		result.Env = make(map[string]string, len(parent.Env)+len(child.Env))
+		for key, value := range parent.Env {
+			result.Env[key] = value
+		}
+		for key, value := range child.Env {
+			result.Env[key] = value
+		}
+	}
+	if child.EnvFile != "" {
+		result.EnvFile = resolveAgainst(baseDir, child.EnvFile)
+	}
+	if child.Workdir != "" {
+		result.Workdir = resolveAgainst(baseDir, child.Workdir)
+	}
+	if len(child.DefaultArgs) > 0 {
+		result.DefaultArgs = child.DefaultArgs
+	}
+	if child.Timeout != "" {
+		result.Timeout = child.Timeout
+	}
+	if child.KillGrace != "" {
+		result.KillGrace = child.KillGrace
+	}
+	if child.Mode != "" {
+		result.Mode = child.Mode
+	}
+	if child.Exec != nil {
+		result.Exec = child.Exec
+	}
+	if child.TTY != nil {
+		result.TTY = child.TTY
+	}
+	if child.NoRebuild != nil {
+		result.NoRebuild = child.NoRebuild
+	}
+	return result
+}
+
+// isOn reports whether a switch such as exec is set to true.
+func isOn(value *bool) bool {
+	return value != nil && *value
+}
+
+func resolveAgainst(baseDir string, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(baseDir, path)
+}
+
+// environment returns the full environment for a command: the inherited
+// process environment, then env_file, then env.
+func (s RunSettings) environment() ([]string, error) {
+	env := os.Environ()
+	if s.EnvFile != "" {
+		fileEnv, err := readEnvFile(s.EnvFile)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, fileEnv...)
+	}
+	for _, key := range sortedKeys(s.Env) {
+		env = append(env, key+"="+s.Env[key])
+	}
+	return env, nil
+}
+
+// readEnvFile parses KEY=VALUE lines. Blank lines, # comments and a leading
+// "export " are ignored, and matching surrounding quotes are stripped.
+func readEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var env []string
+	scanner := bufio.NewScanner(f)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, lineNumber)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env = append(env, key+"="+value)
+	}
+	return env, scanner.Err()
+}
+
+// describe returns one line per configured setting, for list and --help.
+func (s RunSettings) describe() []string {
+	var lines []string
+	if s.Workdir != "" {
+		lines = append(lines, "workdir: "+s.Workdir)
+	}
+	if s.EnvFile != "" {
+		lines = append(lines, "env_file: "+s.EnvFile)
+	}
+	for _, key := range sortedKeys(s.Env) {
+		lines = append(lines, "env: "+key+"="+s.Env[key])
+	}
+	if len(s.DefaultArgs) > 0 {
+		lines = append(lines, "default_args: "+strings.Join(s.DefaultArgs, " "))
+	}
+	if s.Timeout != "" {
+		lines = append(lines, "timeout: "+s.Timeout)
+	}
+	if s.KillGrace != "" {
+		lines = append(lines, "kill_grace: "+s.KillGrace)
+	}
+	if isOn(s.Exec) {
+		lines = append(lines, "exec: true")
+	}
+	if isOn(s.TTY) {
+		lines = append(lines, "tty: true")
+	}
+	if isOn(s.NoRebuild) {
+		lines = append(lines, "no_rebuild: true")
+	}
+	if s.Mode != "" {
+		lines = append(lines, "mode: "+s.Mode)
+	}
+	return lines
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
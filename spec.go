@@ -0,0 +1,359 @@
+// spec.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Types a declared flag can have.
+var flagTypes = []string{"string", "int", "bool", "float", "duration", "strings"}
+
+// cobraTemplate is the built-in template used when a Go command declares
+// flags or arguments.
+const cobraTemplate = "cobra"
+
+var specNamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]*$`)
+
+// FlagSpec declares a flag of a command, given to new-go-command as
+// --flag name:type:default:help.
+type FlagSpec struct {
+	Name    string `yaml:"name" json:"name" toml:"name"`
+	Type    string `yaml:"type,omitempty" json:"type,omitempty" toml:"type,omitempty"`
+	Default string `yaml:"default,omitempty" json:"default,omitempty" toml:"default,omitempty"`
+	Help    string `yaml:"help,omitempty" json:"help,omitempty" toml:"help,omitempty"`
+}
+
+// ArgSpec declares a positional argument of a command, given to
+// new-go-command as --arg name:help. The last argument may be variadic,
+// written name... on the command line.
+type ArgSpec struct {
+	Name     string `yaml:"name" json:"name" toml:"name"`
+	Help     string `yaml:"help,omitempty" json:"help,omitempty" toml:"help,omitempty"`
+	Variadic bool   `yaml:"variadic,omitempty" json:"variadic,omitempty" toml:"variadic,omitempty"`
+}
+
+var flagSpecs []string
+var argSpecs []string
+
+func init() {
+	newGoCommandCmd.Flags().StringArrayVar(&flagSpecs, "flag", nil, "Flag of the command as name:type:default:help, type is one of "+strings.Join(flagTypes, ", ")+" (repeatable)")
+	newGoCommandCmd.Flags().StringArrayVar(&argSpecs, "arg", nil, "Positional argument of the command as name:help, name... for a variadic last argument (repeatable)")
+}
+
+// parseFlagSpec parses name:type:default:help. Everything but the name may be
+// left out, the type defaults to string. The help may contain colons.
+func parseFlagSpec(text string) (FlagSpec, error) {
+	parts := strings.SplitN(text, ":", 4)
+	for len(parts) < 4 {
+		parts = append(parts, "")
+	}
+	spec := FlagSpec{Name: parts[0], Type: parts[1], Default: parts[2], Help: parts[3]}
+	if spec.Type == "" {
+		spec.Type = "string"
+	}
+	return spec, spec.validate()
+}
+
+func (s FlagSpec) validate() error {
+	if !specNamePattern.MatchString(s.Name) {
+		return fmt.Errorf("flag %q: %w", s.Name, errInvalidName)
+	}
+	_, err := s.goDefault()
+	return err
+}
+
+// parseArgSpec parses name:help, where name may end in ... for a variadic
+// argument.
+func parseArgSpec(text string) (ArgSpec, error) {
+	name, help, _ := strings.Cut(text, ":")
+	spec := ArgSpec{Name: strings.TrimSuffix(name, "..."), Help: help, Variadic: strings.HasSuffix(name, "...")}
+	if !specNamePattern.MatchString(spec.Name) {
+		return spec, fmt.Errorf("argument %q: %w", spec.Name, errInvalidName)
+	}
+	return spec, nil
+}
+
+// parseSpecs parses the --flag and --arg values of new-go-command.
+func parseSpecs(flagTexts []string, argTexts []string) ([]FlagSpec, []ArgSpec, error) {
+	var flags []FlagSpec
+	seen := map[string]bool{"help": true}
+	for _, text := range flagTexts {
+		spec, err := parseFlagSpec(text)
+		if err != nil {
+			return nil, nil, err
+		}
+		if seen[spec.Name] {
+			return nil, nil, fmt.Errorf("flag %s is declared twice", spec.Name)
+		}
+		seen[spec.Name] = true
+		flags = append(flags, spec)
+	}
+
+	var args []ArgSpec
+	for i, text := range argTexts {
+		spec, err := parseArgSpec(text)
+		if err != nil {
+			return nil, nil, err
+		}
+		if spec.Variadic && i != len(argTexts)-1 {
+			return nil, nil, fmt.Errorf("argument %s is variadic, only the last argument can be", spec.Name)
+		}
+		args = append(args, spec)
+	}
+	return flags, args, nil
+}
+
+// goDefault returns the flag's default as a Go expression for the scaffold.
+func (s FlagSpec) goDefault() (string, error) {
+	invalid := func(err error) (string, error) {
+		return "", fmt.Errorf("flag %s: default %q is not a valid %s: %w", s.Name, s.Default, s.Type, err)
+	}
+	switch s.Type {
+	case "string":
+		return strconv.Quote(s.Default), nil
+	case "int":
+		if s.Default == "" {
+			return "0", nil
+		}
+		value, err := strconv.Atoi(s.Default)
+		if err != nil {
+			return invalid(err)
+		}
+		return strconv.Itoa(value), nil
+	case "bool":
+		if s.Default == "" {
+			return "false", nil
+		}
+		value, err := strconv.ParseBool(s.Default)
+		if err != nil {
+			return invalid(err)
+		}
+		return strconv.FormatBool(value), nil
+	case "float":
+		if s.Default == "" {
+			return "0", nil
+		}
+		value, err := strconv.ParseFloat(s.Default, 64)
+		if err != nil {
+			return invalid(err)
+		}
+		return strconv.FormatFloat(value, 'g', -1, 64), nil
+	case "duration":
+		// Typed, so the scaffold's time import is used either way
+		if s.Default == "" {
+			return "time.Duration(0)", nil
+		}
+		value, err := time.ParseDuration(s.Default)
+		if err != nil {
+			return invalid(err)
+		}
+		return durationLiteral(value), nil
+	case "strings":
+		if s.Default == "" {
+			return "nil", nil
+		}
+		var quoted []string
+		for _, item := range strings.Split(s.Default, ",") {
+			quoted = append(quoted, strconv.Quote(item))
+		}
+		return "[]string{" + strings.Join(quoted, ", ") + "}", nil
+	}
+	return "", fmt.Errorf("flag %s: unknown type %q, expected one of %s", s.Name, s.Type, strings.Join(flagTypes, ", "))
+}
+
+// durationLiteral writes d in the largest time unit that divides it, e.g.
+// 90*time.Second.
+func durationLiteral(d time.Duration) string {
+	units := []struct {
+		name string
+		size time.Duration
+	}{
+		{"time.Hour", time.Hour},
+		{"time.Minute", time.Minute},
+		{"time.Second", time.Second},
+		{"time.Millisecond", time.Millisecond},
+		{"time.Microsecond", time.Microsecond},
+	}
+	for _, unit := range units {
+		if d%unit.size == 0 {
+			return fmt.Sprintf("%d*%s", d/unit.size, unit.name)
+		}
+	}
+	return fmt.Sprintf("%d", int64(d))
+}
+
+// cobraFlagFunc returns the pflag method defining a flag of the type.
+func cobraFlagFunc(flagType string) string {
+	switch flagType {
+	case "int":
+		return "Int"
+	case "bool":
+		return "Bool"
+	case "float":
+		return "Float64"
+	case "duration":
+		return "Duration"
+	case "strings":
+		return "StringSlice"
+	}
+	return "String"
+}
+
+// goIdentifier turns a flag or argument name into a Go identifier with the
+// given suffix, e.g. dry-run and Flag into dryRunFlag. The suffix keeps names
+// like type from clashing with Go keywords.
+func goIdentifier(name string, suffix string) string {
+	words := strings.FieldsFunc(name, func(r rune) bool { return r == '-' || r == '_' })
+	for i, word := range words {
+		if i == 0 {
+			words[i] = strings.ToLower(word[:1]) + word[1:]
+		} else {
+			words[i] = strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return strings.Join(words, "") + suffix
+}
+
+// argsUsage returns the arguments as shown in a usage line, e.g.
+// <service> [files...].
+func argsUsage(args []ArgSpec) string {
+	var words []string
+	for _, arg := range args {
+		if arg.Variadic {
+			words = append(words, "["+arg.Name+"...]")
+		} else {
+			words = append(words, "<"+arg.Name+">")
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// argsCheck returns the cobra.PositionalArgs expression validating args.
+func argsCheck(args []ArgSpec) string {
+	if len(args) > 0 && args[len(args)-1].Variadic {
+		return fmt.Sprintf("cobra.MinimumNArgs(%d)", len(args)-1)
+	}
+	return fmt.Sprintf("cobra.ExactArgs(%d)", len(args))
+}
+
+// applySpec makes asd's help and completion of a generated command show the
+// flags and arguments it declares. Cobra completes the registered flags. In
+// managed mode the flags are parsed by asd and handed on to the program.
+func applySpec(cmd *cobra.Command, command Command) error {
+	if len(command.Options) == 0 && len(command.Arguments) == 0 {
+		return nil
+	}
+
+	if command.Usage == "" && len(command.Arguments) > 0 {
+		cmd.Use = command.Name + " " + argsUsage(command.Arguments)
+	}
+	if len(command.Arguments) > 0 {
+		lines := []string{"Arguments:"}
+		for _, arg := range command.Arguments {
+			lines = append(lines, fmt.Sprintf("  %-14s%s", arg.Name, arg.Help))
+		}
+		if cmd.Long == "" {
+			cmd.Long = cmd.Short
+		}
+		cmd.Long += "\n\n" + strings.Join(lines, "\n")
+	}
+
+	for _, spec := range command.Options {
+		err := spec.validate()
+		if err != nil {
+			return err
+		}
+		defineFlag(cmd, spec)
+	}
+
+	return nil
+}
+
+// defineFlag registers spec on cmd. The spec is validated, so its default
+// parses.
+func defineFlag(cmd *cobra.Command, spec FlagSpec) {
+	flags := cmd.Flags()
+	switch spec.Type {
+	case "int":
+		value, _ := strconv.Atoi(spec.Default)
+		flags.Int(spec.Name, value, spec.Help)
+	case "bool":
+		value, _ := strconv.ParseBool(spec.Default)
+		flags.Bool(spec.Name, value, spec.Help)
+	case "float":
+		value, _ := strconv.ParseFloat(spec.Default, 64)
+		flags.Float64(spec.Name, value, spec.Help)
+	case "duration":
+		value, _ := time.ParseDuration(spec.Default)
+		flags.Duration(spec.Name, value, spec.Help)
+	case "strings":
+		var value []string
+		if spec.Default != "" {
+			value = strings.Split(spec.Default, ",")
+		}
+		flags.StringSlice(spec.Name, value, spec.Help)
+	default:
+		flags.String(spec.Name, spec.Default, spec.Help)
+	}
+}
+
+// specArgs returns the declared flags set on the command line as
+// --name=value, for the program to parse again in managed mode.
+func specArgs(cmd *cobra.Command, command Command) []string {
+	var args []string
+	for _, spec := range command.Options {
+		flag := cmd.Flags().Lookup(spec.Name)
+		if flag == nil || !flag.Changed {
+			continue
+		}
+		if spec.Type == "strings" {
+			values, _ := cmd.Flags().GetStringSlice(spec.Name)
+			for _, value := range values {
+				args = append(args, "--"+spec.Name+"="+value)
+			}
+			continue
+		}
+		args = append(args, "--"+spec.Name+"="+flag.Value.String())
+	}
+	return args
+}
+
+// findGoModule returns the directory of the go.mod that dir belongs to, or ""
+// when it is not in a module.
+func findGoModule(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// tidyGoModule runs go mod tidy for the module of dir, so the cobra import of
+// a scaffolded command resolves. Failing is only worth a warning, the user
+// can run it later.
+func tidyGoModule(dir string) {
+	module := findGoModule(dir)
+	if module == "" {
+		return
+	}
+	cmd := exec.Command("go", "mod", "tidy")
+	cmd.Dir = module
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: go mod tidy failed in %s, run it before building: %s\n%s", module, err, output)
+	}
+}
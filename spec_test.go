@@ -0,0 +1,84 @@
+// spec_test.go
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseFlagSpec(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    FlagSpec
+		wantErr bool
+	}{
+		{text: "name", want: FlagSpec{Name: "name", Type: "string"}},
+		{text: "count:int:3:How many", want: FlagSpec{Name: "count", Type: "int", Default: "3", Help: "How many"}},
+		{text: "mode::fast:Either: fast or slow", want: FlagSpec{Name: "mode", Type: "string", Default: "fast", Help: "Either: fast or slow"}},
+		{text: "dry-run:bool", want: FlagSpec{Name: "dry-run", Type: "bool"}},
+		{text: "count:int:many", wantErr: true},
+		{text: "timeout:duration:soon", wantErr: true},
+		{text: "size:huge", wantErr: true},
+		{text: "1st", wantErr: true},
+		{text: "", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			spec, err := parseFlagSpec(test.text)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("parsed %q as %+v, want an error", test.text, spec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if spec != test.want {
+				t.Errorf("got %+v, want %+v", spec, test.want)
+			}
+		})
+	}
+}
+
+func TestParseFlagSpecInvalidName(t *testing.T) {
+	_, err := parseFlagSpec("no spaces")
+	if !errors.Is(err, errInvalidName) {
+		t.Errorf("got %v, want %v", err, errInvalidName)
+	}
+}
+
+func TestGoDefault(t *testing.T) {
+	tests := []struct {
+		flagType string
+		value    string
+		want     string
+	}{
+		{"string", "", `""`},
+		{"string", `say "hi"`, `"say \"hi\""`},
+		{"int", "", "0"},
+		{"int", "042", "42"},
+		{"bool", "", "false"},
+		{"bool", "1", "true"},
+		{"float", "1.50", "1.5"},
+		{"duration", "", "time.Duration(0)"},
+		{"duration", "90s", "90*time.Second"},
+		{"duration", "1h30m", "90*time.Minute"},
+		{"duration", "1500ms", "1500*time.Millisecond"},
+		{"strings", "", "nil"},
+		{"strings", "a,b", `[]string{"a", "b"}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.flagType+"/"+test.value, func(t *testing.T) {
+			got, err := FlagSpec{Name: "flag", Type: test.flagType, Default: test.value}.goDefault()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+// store.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// RegistryStore loads and saves the command registry. Every command goes
+// through a store instead of touching the file directly.
+type RegistryStore interface {
+	// Path returns the file backing the store.
+	Path() string
+	// Load reads the registry, migrating it to the current version in memory.
+	Load() (Config, error)
+	// Save replaces the registry with config.
+	Save(config Config) error
+	// Update runs a locked read-modify-write. Nothing is written if update fails.
+	Update(update func(config *Config) error) error
+	// Migrate upgrades the file to the current version. review gets the old
+	// and new contents and decides whether the new contents are written.
+	Migrate(review func(before []byte, after []byte, fromVersion int) bool) error
+}
+
+// registryFormat encodes and decodes the registry in one file format.
+type registryFormat interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type yamlFormat struct{}
+
+func (yamlFormat) Marshal(v interface{}) ([]byte, error) {
+	return yaml.Marshal(v)
+}
+
+func (yamlFormat) Unmarshal(data []byte, v interface{}) error {
+	return yaml.Unmarshal(data, v)
+}
+
+type jsonFormat struct{}
+
+func (jsonFormat) Marshal(v interface{}) ([]byte, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func (jsonFormat) Unmarshal(data []byte, v interface{}) error {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+type tomlFormat struct{}
+
+func (tomlFormat) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := toml.NewEncoder(&buf).Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (tomlFormat) Unmarshal(data []byte, v interface{}) error {
+	return toml.Unmarshal(data, v)
+}
+
+// registryFormats maps file extensions to formats. Unknown extensions are
+// treated as YAML.
+var registryFormats = map[string]registryFormat{
+	".yaml": yamlFormat{},
+	".yml":  yamlFormat{},
+	".json": jsonFormat{},
+	".toml": tomlFormat{},
+}
+
+// fileStore is a RegistryStore backed by a single file.
+type fileStore struct {
+	path   string
+	format registryFormat
+}
+
+// newRegistryStore returns a store for path, picking the format by extension.
+func newRegistryStore(path string) RegistryStore {
+	format, ok := registryFormats[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		format = yamlFormat{}
+	}
+	return &fileStore{path: path, format: format}
+}
+
+// registry returns the store for the discovered config file.
+func registry() RegistryStore {
+	return newRegistryStore(configPath)
+}
+
+func (s *fileStore) Path() string {
+	return s.path
+}
+
+func (s *fileStore) Load() (Config, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return Config{}, err
+	}
+	config, _, err := s.decode(data)
+	return config, err
+}
+
+func (s *fileStore) Save(config Config) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.write(config)
+}
+
+func (s *fileStore) Update(update func(config *Config) error) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	config, _, err := s.decode(data)
+	if err != nil {
+		return err
+	}
+
+	err = update(&config)
+	if err != nil {
+		return err
+	}
+
+	return s.write(config)
+}
+
+func (s *fileStore) Migrate(review func(before []byte, after []byte, fromVersion int) bool) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	config, fromVersion, err := s.decode(data)
+	if err != nil {
+		return err
+	}
+
+	migrated := data
+	if fromVersion != currentConfigVersion {
+		migrated, err = s.format.Marshal(&config)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !review(data, migrated, fromVersion) {
+		return nil
+	}
+	return writeFileAtomic(s.path, migrated, 0644)
+}
+
+// decode parses data into a generic document, runs pending migrations on it
+// and converts the result into a Config. It also returns the version found
+// in the file.
+func (s *fileStore) decode(data []byte) (Config, int, error) {
+	var raw interface{}
+	err := s.format.Unmarshal(data, &raw)
+	if err != nil {
+		return Config{}, 0, err
+	}
+
+	doc, ok := normalizeDocument(raw).(map[string]interface{})
+	if !ok {
+		if raw != nil {
+			return Config{}, 0, fmt.Errorf("%s: expected a mapping at the top level", s.path)
+		}
+		doc = map[string]interface{}{}
+	}
+
+	doc, fromVersion, err := migrateDocument(doc)
+	if err != nil {
+		return Config{}, fromVersion, err
+	}
+
+	// The migrated document goes back through the file's own format to become
+	// typed structs, so that format's rules apply, e.g. YAML reading
+	// PORT: 8080 into a string.
+	migrated, err := s.format.Marshal(doc)
+	if err != nil {
+		return Config{}, fromVersion, err
+	}
+	var config Config
+	err = s.format.Unmarshal(migrated, &config)
+	if err != nil {
+		return Config{}, fromVersion, err
+	}
+	return config, fromVersion, nil
+}
+
+func (s *fileStore) write(config Config) error {
+	config.Version = currentConfigVersion
+	data, err := s.format.Marshal(&config)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data, 0644)
+}
+
+// lock takes an exclusive lock on the registry for a read-modify-write.
+// The lock lives on a sibling .lock file so the registry itself can be
+// replaced by rename while the lock is held. Call the returned function to
+// release it.
+func (s *fileStore) lock() (func(), error) {
+	f, err := os.OpenFile(s.path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = lockFile(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// normalizeDocument converts decoded YAML, JSON or TOML values into plain
+// map[string]interface{} and []interface{} trees.
+func normalizeDocument(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[fmt.Sprint(key)] = normalizeDocument(item)
+		}
+		return result
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[key] = normalizeDocument(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = normalizeDocument(item)
+		}
+		return result
+	case []map[string]interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = normalizeDocument(item)
+		}
+		return result
+	default:
+		return v
+	}
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place, so a crash never leaves a truncated file behind.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
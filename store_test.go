@@ -0,0 +1,118 @@
+// store_test.go
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// useConfig points configPath at a fresh, empty registry in a temp folder
+// for the duration of the test.
+func useConfig(t *testing.T, name string) {
+	t.Helper()
+	previous := configPath
+	configPath = filepath.Join(t.TempDir(), name)
+	t.Cleanup(func() { configPath = previous })
+
+	err := registry().Save(Config{})
+	if err != nil {
+		t.Fatalf("could not create %s: %s", configPath, err)
+	}
+}
+
+func TestConcurrentNewCategories(t *testing.T) {
+	const count = 20
+
+	for _, name := range []string{"commands.yaml", "commands.json", "commands.toml"} {
+		t.Run(name, func(t *testing.T) {
+			useConfig(t, name)
+
+			errs := make([]error, count)
+			var wg sync.WaitGroup
+			for i := 0; i < count; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					errs[i] = updateYAMLWithNewCategory(fmt.Sprintf("category%d", i), "")
+				}(i)
+			}
+			wg.Wait()
+
+			for i, err := range errs {
+				if err != nil {
+					t.Errorf("category%d: %s", i, err)
+				}
+			}
+
+			config, err := registry().Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			present := make(map[string]bool)
+			for _, category := range config.Categories {
+				present[category.Name] = true
+			}
+			for i := 0; i < count; i++ {
+				if !present[fmt.Sprintf("category%d", i)] {
+					t.Errorf("category%d is missing, got %d of %d categories", i, len(config.Categories), count)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateKeepsFileOnError(t *testing.T) {
+	useConfig(t, "commands.yaml")
+
+	err := updateYAMLWithNewCategory("tools", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = updateYAMLWithNewCategory("tools", "")
+	if err == nil {
+		t.Fatal("adding tools twice succeeded")
+	}
+
+	config, err := registry().Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Categories) != 1 {
+		t.Errorf("got %d categories, want 1", len(config.Categories))
+	}
+}
+
+func TestLoadYAMLScalarsIntoStrings(t *testing.T) {
+	useConfig(t, "commands.yaml")
+	data := `categories:
+  - name: tools
+    path: tools
+    env: {PORT: 8080, DEBUG: true}
+    commands:
+      - name: serve
+        runner: shell
+        default_args: [--retries, 3, --ratio, 0.5]
+`
+	err := ioutil.WriteFile(configPath, []byte(data), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := registry().Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	category := config.Categories[0]
+	wantEnv := map[string]string{"PORT": "8080", "DEBUG": "true"}
+	if !reflect.DeepEqual(category.Env, wantEnv) {
+		t.Errorf("got env %v, want %v", category.Env, wantEnv)
+	}
+	wantArgs := []string{"--retries", "3", "--ratio", "0.5"}
+	if args := category.Commands[0].DefaultArgs; !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("got default args %q, want %q", args, wantArgs)
+	}
+}
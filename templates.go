@@ -0,0 +1,498 @@
+// templates.go
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// templateManifest is the optional file in a template folder describing it.
+const templateManifest = "template.yaml"
+
+// Where templates come from, in lookup order.
+const (
+	templateSourceWorkspace = "workspace"
+	templateSourceUser      = "user"
+	templateSourceBuiltin   = "built-in"
+)
+
+// scaffoldTemplate creates the files of a new command. File names and
+// contents are Go text/templates, so a template can create several files,
+// e.g. {{.Name}}.go and {{.Name}}_test.go.
+type scaffoldTemplate struct {
+	Name        string
+	Source      string
+	Dir         string
+	Description string `yaml:"description"`
+	// Runner restricts the template to commands of one runner kind.
+	Runner string `yaml:"runner"`
+	// Files maps file name templates to content templates.
+	Files map[string]string `yaml:"-"`
+	// Executable holds the file name templates that are created executable.
+	Executable map[string]bool `yaml:"-"`
+}
+
+// templateData is what templates can refer to, e.g. {{.Name}}.
+type templateData struct {
+	Name        string
+	Category    string
+	Runner      string
+	Description string
+	Author      string
+	Date        string
+	Year        int
+	// Shebang is the first line of scripts in the command's language
+	Shebang string
+	// Flags and Args are the spec given with new-go-command --flag and --arg
+	Flags []FlagSpec
+	Args  []ArgSpec
+	// HasModule is set when the command's folder is inside a Go module
+	HasModule bool
+}
+
+// builtinTemplates are used when no workspace or user template has the name.
+var builtinTemplates = []scaffoldTemplate{
+	{
+		Name:        runnerGo,
+		Description: "Go program printing a greeting",
+		Runner:      runnerGo,
+		Files: map[string]string{
+			"{{.Name}}.go": `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("Running {{.Name}} program")
+}
+`,
+		},
+	},
+	{
+		Name:        runnerShell,
+		Description: "bash script printing a greeting",
+		Runner:      runnerShell,
+		Files: map[string]string{
+			"{{.Name}}.sh": `{{.Shebang}}
+
+echo "Running {{.Name}} Linux command"
+`,
+		},
+		Executable: map[string]bool{"{{.Name}}.sh": true},
+	},
+	{
+		Name:        runnerPython,
+		Description: "Python script printing a greeting",
+		Runner:      runnerPython,
+		Files: map[string]string{
+			"{{.Name}}.py": `{{.Shebang}}
+import sys
+
+
+def main(args):
+    print("Running {{.Name}} program")
+
+
+if __name__ == "__main__":
+    main(sys.argv[1:])
+`,
+		},
+		Executable: map[string]bool{"{{.Name}}.py": true},
+	},
+	{
+		Name:        runnerNode,
+		Description: "Node script printing a greeting",
+		Runner:      runnerNode,
+		Files: map[string]string{
+			"{{.Name}}.js": `{{.Shebang}}
+
+const args = process.argv.slice(2);
+
+console.log("Running {{.Name}} program");
+`,
+		},
+		Executable: map[string]bool{"{{.Name}}.js": true},
+	},
+	{
+		Name:        runnerRuby,
+		Description: "Ruby script printing a greeting",
+		Runner:      runnerRuby,
+		Files: map[string]string{
+			"{{.Name}}.rb": `{{.Shebang}}
+
+args = ARGV
+
+puts "Running {{.Name}} program"
+`,
+		},
+		Executable: map[string]bool{"{{.Name}}.rb": true},
+	},
+	{
+		Name:        cobraTemplate,
+		Description: "Go program using cobra for the declared flags and arguments",
+		Runner:      runnerGo,
+		Files: map[string]string{
+			"{{.Name}}.go": `package main
+
+import (
+	"fmt"
+	"os"
+{{- range .Flags}}{{if eq .Type "duration"}}
+	"time"
+{{- break}}{{end}}{{end}}
+
+	"github.com/spf13/cobra"
+)
+
+func main() {
+	cmd := &cobra.Command{
+		Use:   {{quote (printf "%s %s" .Name (argsUsage .Args))}},
+		Short: {{quote (or .Description (printf "Runs %s" .Name))}},
+		Args:  {{argsCheck .Args}},
+	}
+{{- range .Flags}}
+	{{goIdentifier .Name "Flag"}} := cmd.Flags().{{cobraFlagFunc .Type}}({{quote .Name}}, {{goDefault .}}, {{quote .Help}})
+{{- end}}
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+{{- range $i, $arg := .Args}}
+		{{goIdentifier $arg.Name "Arg"}} := {{if $arg.Variadic}}args[{{$i}}:]{{else}}args[{{$i}}]{{end}}
+{{- end}}
+
+		fmt.Println("Running {{.Name}} program")
+{{- range .Args}}
+		fmt.Printf("  {{.Name}}: %v\n", {{goIdentifier .Name "Arg"}})
+{{- end}}
+{{- range .Flags}}
+		fmt.Printf("  --{{.Name}}: %v\n", *{{goIdentifier .Name "Flag"}})
+{{- end}}
+	}
+
+	if err := cmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+`,
+			"{{if not .HasModule}}go.mod{{end}}": `module {{.Category}}
+
+go 1.21
+
+require github.com/spf13/cobra v1.7.0
+`,
+		},
+	},
+}
+
+// templateFuncs are the functions templates can call besides the text/template
+// built-ins, mostly to write Go code for the cobra template.
+var templateFuncs = template.FuncMap{
+	"quote":         strconv.Quote,
+	"goIdentifier":  goIdentifier,
+	"cobraFlagFunc": cobraFlagFunc,
+	"argsUsage":     argsUsage,
+	"argsCheck":     argsCheck,
+	"goDefault": func(spec FlagSpec) (string, error) {
+		return spec.goDefault()
+	},
+}
+
+var templateName string
+
+var templatesCmd = &cobra.Command{
+	Use:   "templates",
+	Short: "Manages the templates used to create new commands",
+}
+
+var templatesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the templates available to --template",
+	Long: "Lists the templates available to --template. Templates are folders of Go text/template\n" +
+		"files in .asd/templates next to commands.yaml or in $XDG_CONFIG_HOME/asd/templates.\n" +
+		"A template.yaml in the folder may set a description and the runner it is for.\n" +
+		"Templates can use {{.Name}}, {{.Category}}, {{.Runner}}, {{.Description}}, {{.Author}},\n" +
+		"{{.Date}}, {{.Year}}, {{.Shebang}}, {{.Flags}}, {{.Args}} and {{.HasModule}}, in file names\n" +
+		"as well as contents. A file whose name renders empty is not created.",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		templates, err := listTemplates()
+		if err != nil {
+			fail(exitFailure, "Could not list templates: %s", err)
+			return
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "NAME\tRUNNER\tSOURCE\tDESCRIPTION")
+		for _, t := range templates {
+			runner := t.Runner
+			if runner == "" {
+				runner = "any"
+			}
+			source := t.Source
+			if t.Dir != "" {
+				source += " (" + t.Dir + ")"
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", t.Name, runner, source, t.Description)
+		}
+		writer.Flush()
+	},
+}
+
+func init() {
+	templatesCmd.AddCommand(templatesListCmd)
+	for _, cmd := range []*cobra.Command{newGoCommandCmd, newLinuxCommandCmd, newCommandCmd} {
+		cmd.Flags().StringVar(&templateName, "template", "", "Template to create the command's files from, see asd templates list")
+	}
+}
+
+// templateDirs returns the template folders with their source, in lookup
+// order.
+func templateDirs() [][2]string {
+	var dirs [][2]string
+	if configPath != "" {
+		dirs = append(dirs, [2]string{filepath.Join(filepath.Dir(configPath), ".asd", "templates"), templateSourceWorkspace})
+	}
+	if dir, err := userConfigDir(); err == nil {
+		dirs = append(dirs, [2]string{filepath.Join(dir, "templates"), templateSourceUser})
+	}
+	return dirs
+}
+
+// listTemplates returns every available template, sorted by name. A template
+// in the workspace hides a user or built-in template of the same name.
+func listTemplates() ([]scaffoldTemplate, error) {
+	seen := make(map[string]bool)
+	var templates []scaffoldTemplate
+	for _, dir := range templateDirs() {
+		entries, err := ioutil.ReadDir(dir[0])
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() || seen[entry.Name()] {
+				continue
+			}
+			t, err := loadTemplate(filepath.Join(dir[0], entry.Name()), dir[1])
+			if err != nil {
+				return nil, err
+			}
+			seen[t.Name] = true
+			templates = append(templates, t)
+		}
+	}
+	for _, t := range builtinTemplates {
+		if !seen[t.Name] {
+			t.Source = templateSourceBuiltin
+			templates = append(templates, t)
+		}
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates, nil
+}
+
+// findTemplate returns the template with the given name.
+func findTemplate(name string) (scaffoldTemplate, error) {
+	templates, err := listTemplates()
+	if err != nil {
+		return scaffoldTemplate{}, err
+	}
+	for _, t := range templates {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	return scaffoldTemplate{}, fmt.Errorf("template %s %w", name, errNotFound)
+}
+
+// loadTemplate reads a template folder. Every file except the manifest is
+// part of the template, including files in subfolders.
+func loadTemplate(dir string, source string) (scaffoldTemplate, error) {
+	t := scaffoldTemplate{
+		Name:       filepath.Base(dir),
+		Source:     source,
+		Dir:        dir,
+		Files:      make(map[string]string),
+		Executable: make(map[string]bool),
+	}
+
+	manifest, err := ioutil.ReadFile(filepath.Join(dir, templateManifest))
+	if err == nil {
+		err = yaml.Unmarshal(manifest, &t)
+		if err != nil {
+			return t, fmt.Errorf("%s: %w", filepath.Join(dir, templateManifest), err)
+		}
+	} else if !os.IsNotExist(err) {
+		return t, err
+	}
+
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		relative, err := filepath.Rel(dir, path)
+		if err != nil || relative == templateManifest {
+			return err
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(relative)
+		t.Files[name] = string(content)
+		t.Executable[name] = info.Mode()&0111 != 0
+		return nil
+	})
+	return t, err
+}
+
+// scaffoldCommand creates the files of a new command in dir from the
+// template selected with --template, or the template of the runner's
+// language. Go commands declaring flags or arguments default to the cobra
+// template. It refuses to overwrite existing files.
+func scaffoldCommand(dir string, command Command, categoryPath string) error {
+	name := templateName
+	if name == "" {
+		name = command.Runner
+		if language, ok := languageForRunner(command.Runner); ok {
+			name = language.Template
+		}
+		if len(command.Options) > 0 || len(command.Arguments) > 0 {
+			name = cobraTemplate
+		}
+	}
+	t, err := findTemplate(name)
+	if err != nil {
+		return err
+	}
+	if t.Runner != "" && t.Runner != command.Runner {
+		return fmt.Errorf("template %s %w: it creates %s commands, not %s", t.Name, errWrongRunner, t.Runner, command.Runner)
+	}
+
+	data := newTemplateData(dir, command, categoryPath)
+
+	// Render everything before writing, so a broken template creates nothing
+	files := make(map[string][]byte)
+	modes := make(map[string]os.FileMode)
+	for nameTemplate, contentTemplate := range t.Files {
+		fileName, err := renderTemplate(t.Name, nameTemplate, data)
+		if err != nil {
+			return err
+		}
+		// A file name rendering empty leaves the file out
+		if strings.TrimSpace(fileName) == "" {
+			continue
+		}
+		content, err := renderTemplate(t.Name, contentTemplate, data)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, filepath.FromSlash(fileName))
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%s %w", path, errAlreadyExists)
+		}
+		files[path] = []byte(content)
+		modes[path] = 0644
+		if t.Executable[nameTemplate] {
+			modes[path] = 0755
+		}
+	}
+
+	runner, err := runnerFor(command)
+	if err != nil {
+		return err
+	}
+	source := runner.Source(dir, command)
+	if _, ok := files[source]; !ok && runner.SourceExt != "" {
+		return fmt.Errorf("template %s does not create %s", t.Name, filepath.Base(source))
+	}
+
+	for path, content := range files {
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(path, content, modes[path])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newTemplateData returns what templates can refer to for command, created
+// in dir.
+func newTemplateData(dir string, command Command, categoryPath string) templateData {
+	now := time.Now()
+	data := templateData{
+		Name:        command.Name,
+		Category:    categoryPath,
+		Runner:      command.Runner,
+		Description: command.Description,
+		Author:      templateAuthor(),
+		Date:        now.Format("2006-01-02"),
+		Year:        now.Year(),
+		Flags:       command.Options,
+		Args:        command.Arguments,
+		HasModule:   findGoModule(dir) != "",
+	}
+	if language, ok := languageForRunner(command.Runner); ok {
+		data.Shebang = language.Shebang
+	}
+	return data
+}
+
+func renderTemplate(name string, text string, data templateData) (string, error) {
+	parsed, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("template %s: %w", name, err)
+	}
+	var output bytes.Buffer
+	err = parsed.Execute(&output, data)
+	if err != nil {
+		return "", fmt.Errorf("template %s: %w", name, err)
+	}
+	return output.String(), nil
+}
+
+// templateAuthor returns the git user name, falling back to the login name.
+func templateAuthor() string {
+	output, err := exec.Command("git", "config", "user.name").Output()
+	if err == nil {
+		if author := strings.TrimSpace(string(output)); author != "" {
+			return author
+		}
+	}
+	return currentUserName()
+}
+
+// categoryPathOf returns the full path of category within categories.
+func categoryPathOf(category *Category, categories []Category) string {
+	for i := range categories {
+		if &categories[i] == category {
+			return categories[i].Name
+		}
+		if path := categoryPathOf(category, categories[i].Subcategories); path != "" {
+			return categories[i].Name + "/" + path
+		}
+	}
+	return ""
+}
@@ -0,0 +1,58 @@
+// templates_test.go
+package main
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestCobraTemplateBuilds(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a Go program")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	useConfig(t, "commands.yaml")
+
+	specs := map[string][]string{
+		"no-defaults": {"name", "count:int", "verbose:bool", "ratio:float", "wait:duration", "tags:strings"},
+		"defaults":    {"name:string:world:Who to greet", "count:int:3", "verbose:bool:true", "ratio:float:0.5", "wait:duration:90s", "tags:strings:a,b"},
+		"durations":   {"wait:duration", "timeout:duration:1m"},
+	}
+	for name, flags := range specs {
+		t.Run(name, func(t *testing.T) {
+			options, arguments, err := parseSpecs(flags, []string{"target:What to run on", "files...:Extra files"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			command := Command{Name: "hello", Runner: runnerGo, Options: options, Arguments: arguments}
+			err = scaffoldCommand(dir, command, "tools")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Reuse asd's go.sum, which covers cobra, so the build needs no network
+			sums, err := ioutil.ReadFile("go.sum")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ioutil.WriteFile(filepath.Join(dir, "go.sum"), sums, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			build := exec.Command("go", "build", "-mod=mod", "-o", filepath.Join(dir, "hello"), ".")
+			build.Dir = dir
+			output, err := build.CombinedOutput()
+			if err != nil {
+				source, _ := ioutil.ReadFile(filepath.Join(dir, "hello.go"))
+				t.Fatalf("scaffold does not build: %s\n%s\n%s", err, output, source)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+//go:build !windows
+
+// tty_unix.go
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"golang.org/x/term"
+)
+
+// ptyDrainTimeout bounds how long asd waits for the last output of a tty
+// command, in case a background process keeps the terminal open.
+const ptyDrainTimeout = 2 * time.Second
+
+// startWithPTY starts cmd under a new pseudo-terminal. asd's terminal is put
+// in raw mode and its window size is kept in sync with the child's. The
+// terminal merges stdout and stderr, both go to cmd.Stdout if set, otherwise
+// to asd's stdout. Detached commands get no input and leave asd's terminal
+// alone. The returned function restores the terminal and flushes remaining
+// output.
+func startWithPTY(cmd *exec.Cmd, detached bool) (func(), error) {
+	output := cmd.Stdout
+	if output == nil {
+		output = os.Stdout
+	}
+	// pty.Start only attaches the terminal to streams that are not set
+	cmd.Stdout = nil
+	cmd.Stderr = nil
+	ptmx, err := pty.Start(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	stdinFd := int(os.Stdin.Fd())
+	interactive := !detached && term.IsTerminal(stdinFd)
+
+	// Propagate window size changes
+	winch := make(chan os.Signal, 1)
+	if interactive {
+		signal.Notify(winch, syscall.SIGWINCH)
+		go func() {
+			for range winch {
+				pty.InheritSize(os.Stdin, ptmx)
+			}
+		}()
+		winch <- syscall.SIGWINCH
+	}
+
+	var oldState *term.State
+	if interactive {
+		oldState, err = term.MakeRaw(stdinFd)
+		if err != nil {
+			oldState = nil
+		}
+	}
+
+	// The stdin copy blocks on a read until asd exits, so it is not waited for
+	if !detached {
+		go io.Copy(ptmx, os.Stdin)
+	}
+
+	outputDone := make(chan struct{})
+	go func() {
+		io.Copy(output, ptmx)
+		close(outputDone)
+	}()
+
+	return func() {
+		select {
+		case <-outputDone:
+		case <-time.After(ptyDrainTimeout):
+		}
+		signal.Stop(winch)
+		close(winch)
+		if oldState != nil {
+			term.Restore(stdinFd, oldState)
+		}
+		ptmx.Close()
+	}, nil
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+// tty_windows.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// startWithPTY falls back to a regular start, pseudo-terminals are not
+// supported on Windows.
+func startWithPTY(cmd *exec.Cmd, detached bool) (func(), error) {
+	fmt.Fprintln(os.Stderr, "tty mode is not supported on windows, running without a pseudo-terminal")
+	return startProcess(cmd, detached)
+}
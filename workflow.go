@@ -0,0 +1,225 @@
+// workflow.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Step is one command run by a workflow. {prev} in args and env values is
+// replaced by the trimmed stdout of the previous step, an arg that is exactly
+// {args} expands to the arguments the workflow was run with.
+type Step struct {
+	Command         string            `yaml:"command" json:"command" toml:"command"`
+	Args            []string          `yaml:"args,omitempty" json:"args,omitempty" toml:"args,omitempty"`
+	Env             map[string]string `yaml:"env,omitempty" json:"env,omitempty" toml:"env,omitempty"`
+	ContinueOnError bool              `yaml:"continue_on_error,omitempty" json:"continue_on_error,omitempty" toml:"continue_on_error,omitempty"`
+}
+
+// prevStdoutEnv also exposes the previous step's stdout to the next step.
+const prevStdoutEnv = "ASD_PREV_STDOUT"
+
+// registeredCommand is a command from the merged registry with the folder
+// it runs in.
+type registeredCommand struct {
+	Command Command
+	Dir     string
+}
+
+// registeredCommands maps the slash separated path of every command, e.g.
+// "ops/db/backup", to the command. Workflows look their steps up here.
+var registeredCommands map[string]registeredCommand
+
+// indexCommands collects every command in the category tree by path.
+func indexCommands(categories []Category, parentPath string) map[string]registeredCommand {
+	index := make(map[string]registeredCommand)
+	for _, category := range categories {
+		categoryPath := joinCategoryPath(parentPath, category.Name)
+		for _, command := range category.Commands {
+			index[categoryPath+"/"+command.Name] = registeredCommand{
+				Command: command,
+				Dir:     commandDir(category, command),
+			}
+		}
+		for path, command := range indexCommands(category.Subcategories, categoryPath) {
+			index[path] = command
+		}
+	}
+	return index
+}
+
+// isWorkflow reports whether the command runs other commands instead of a
+// program of its own.
+func (c Command) isWorkflow() bool {
+	return len(c.Steps) > 0
+}
+
+// describeSteps returns one line per step for help output.
+func (c Command) describeSteps() []string {
+	var lines []string
+	for i, step := range c.Steps {
+		line := fmt.Sprintf("%d. %s", i+1, strings.Join(append([]string{step.Command}, step.Args...), " "))
+		if step.ContinueOnError {
+			line += " (continue on error)"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// runRegisteredCommand runs the command at path with args and returns its
+// exit code. Output goes to stdout and stderr, or to asd's own when they are
+// nil.
+func runRegisteredCommand(path string, command Command, dir string, args []string, stdout io.Writer, stderr io.Writer) int {
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	// Workflows run the hooks of their own category around all steps, on top
+	// of those each step runs
+	if command.isWorkflow() {
+		return runWithHooks(command.ResolvedHooks, path, args, command.Resolved, stderr, func() int {
+			return runWorkflow(command, args, stdout, stderr, nil)
+		})
+	}
+
+	runner, err := runnerFor(command)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err)
+		return exitConfig
+	}
+	argv, err := runner.Invocation(dir, command, args)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err)
+		return exitConfig
+	}
+	// Building is part of the run, so a failing before hook stops it too
+	return runWithHooks(command.ResolvedHooks, path, args, command.Resolved, stderr, func() int {
+		if runner.Builds(command) && rebuildEnabled(command.Resolved) {
+			err := ensureGoBinary(runner.Source(dir, command))
+			if err != nil {
+				fmt.Fprintf(stderr, "Could not rebuild %s, not running the outdated binary: %s\n", command.Name, err)
+				return exitFailure
+			}
+		}
+		if runner.Compiled && !runner.Builds(command) {
+			err := compileGoRun(runner.Source(dir, command))
+			if err != nil {
+				fmt.Fprintf(stderr, "Could not compile %s: %s\n", command.Name, err)
+				return exitFailure
+			}
+		}
+		// Keep stdout for the program, so asd can be used in pipes
+		fmt.Fprintf(stderr, "%s: %s\n", command.Name, runner.Artifact(dir, command))
+		return executeProgram(argv, command.Resolved, stdout, stderr)
+	})
+}
+
+// runWorkflow runs the steps of a workflow in order and stops at the first
+// step that fails, unless it sets continue_on_error. active holds the paths
+// of the nested workflows that are already running, to catch steps that
+// refer back to them.
+func runWorkflow(workflow Command, args []string, stdout io.Writer, stderr io.Writer, active map[string]bool) int {
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	if active == nil {
+		active = make(map[string]bool)
+	}
+
+	prev := ""
+	for i, step := range workflow.Steps {
+		label := fmt.Sprintf("[%s %d/%d] %s", workflow.Name, i+1, len(workflow.Steps), step.Command)
+
+		path := strings.Trim(step.Command, "/")
+		target, ok := registeredCommands[path]
+		if !ok {
+			fmt.Fprintf(stderr, "%s: command not found\n", label)
+			return exitNotFound
+		}
+		if active[path] {
+			fmt.Fprintf(stderr, "%s: workflow %s runs itself\n", label, path)
+			return exitConfig
+		}
+
+		// Steps take the command's default_args like any other way of running it
+		stepArgs := append(append([]string{}, target.Command.Resolved.DefaultArgs...), expandStepArgs(step.Args, args, prev)...)
+		fmt.Fprintf(stderr, "%s: running\n", label)
+		started := time.Now()
+
+		stepCommand := target.Command
+		stepCommand.Resolved.Detached = workflow.Resolved.Detached
+		code := runNeeds(path, stepCommand, stdout, stderr)
+		if code != exitOK {
+			fmt.Fprintf(stderr, "%s: prerequisites failed with exit code %d\n", label, code)
+			return code
+		}
+
+		// Show the output as usual and keep it for the next step. Prerequisites
+		// of nested steps write to it side by side.
+		var captured syncBuffer
+		output := io.MultiWriter(stdout, &captured)
+
+		if stepCommand.isWorkflow() {
+			active[path] = true
+			code = runWorkflow(stepCommand, stepArgs, output, stderr, active)
+			delete(active, path)
+		} else {
+			command := stepCommand
+			command.Resolved = stepSettings(command.Resolved, step, prev)
+			code = runRegisteredCommand(path, command, target.Dir, stepArgs, output, stderr)
+		}
+		prev = strings.TrimRight(string(captured.Bytes()), "\r\n")
+		elapsed := time.Since(started).Round(time.Millisecond)
+
+		if code == exitOK {
+			markSatisfied(path)
+			fmt.Fprintf(stderr, "%s: ok (%s)\n", label, elapsed)
+			continue
+		}
+		if step.ContinueOnError {
+			fmt.Fprintf(stderr, "%s: failed with exit code %d, continuing (%s)\n", label, code, elapsed)
+			continue
+		}
+		fmt.Fprintf(stderr, "%s: failed with exit code %d (%s)\n", label, code, elapsed)
+		if skipped := len(workflow.Steps) - i - 1; skipped > 0 {
+			fmt.Fprintf(stderr, "[%s] skipped %d remaining step(s)\n", workflow.Name, skipped)
+		}
+		return code
+	}
+	return exitOK
+}
+
+// expandStepArgs fills in {prev} and {args} in a step's arguments.
+func expandStepArgs(template []string, args []string, prev string) []string {
+	var expanded []string
+	for _, element := range template {
+		if element == "{args}" {
+			expanded = append(expanded, args...)
+			continue
+		}
+		expanded = append(expanded, strings.ReplaceAll(element, "{prev}", prev))
+	}
+	return expanded
+}
+
+// stepSettings layers a step's env over the settings of the command it runs.
+// Steps never exec, the workflow has to keep running after them.
+func stepSettings(settings RunSettings, step Step, prev string) RunSettings {
+	env := make(map[string]string)
+	for key, value := range settings.Env {
+		env[key] = value
+	}
+	for key, value := range step.Env {
+		env[key] = strings.ReplaceAll(value, "{prev}", prev)
+	}
+	env[prevStdoutEnv] = prev
+	settings.Env = env
+	settings.Exec = nil
+	return settings
+}